@@ -0,0 +1,141 @@
+package metadata
+
+import (
+	"github.com/go-errors/errors"
+	"io"
+	"rbmetadata-go/tools"
+)
+
+const (
+	AmrNbMagic   = "#!AMR\n"
+	AmrWbMagic   = "#!AMR-WB\n"
+	AmrNbMcMagic = "#!AMR_MC1.0\n"
+	AmrWbMcMagic = "#!AMR-WB_MC1.0\n"
+
+	// Every AMR frame holds 20ms of audio
+	AmrFrameMs = 20
+)
+
+var (
+	// Frame payload sizes in bytes (without the header byte), indexed by
+	// frame type. Reserved types and "no data" frames carry no payload.
+	AmrNbFrameSizes = [16]int{12, 13, 15, 17, 19, 20, 26, 31, 5, 0, 0, 0, 0, 0, 0, 0}
+	AmrWbFrameSizes = [16]int{17, 23, 32, 36, 40, 46, 50, 58, 60, 5, 0, 0, 0, 0, 0, 0}
+
+	// Bit rates in bits per second, indexed by frame type. Comfort noise
+	// (SID) frames are sent every 8th frame, which is where the averaged
+	// rate for them comes from.
+	AmrNbBitrates = [16]int{4750, 5150, 5900, 6700, 7400, 7950, 10200, 12200, 1800, 0, 0, 0, 0, 0, 0, 0}
+	AmrWbBitrates = [16]int{6600, 8850, 12650, 14250, 15850, 18250, 19850, 23050, 23850, 1750, 0, 0, 0, 0, 0, 0}
+)
+
+func GetAmrMetadata(fd *tools.File, id3 *Mp3Entry) error {
+	var buf [15]byte
+
+	if _, err := fd.Seek(0, io.SeekStart); err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	rd, err := fd.Read(buf[:])
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	var headerLen int
+	var multiChannel bool
+	var frameSizes, bitrates *[16]int
+
+	switch {
+	case rd >= len(AmrNbMagic) && string(buf[:len(AmrNbMagic)]) == AmrNbMagic:
+		id3.Codec = AfmtAmrNb
+		headerLen = len(AmrNbMagic)
+	case rd >= len(AmrWbMagic) && string(buf[:len(AmrWbMagic)]) == AmrWbMagic:
+		id3.Codec = AfmtAmrWb
+		headerLen = len(AmrWbMagic)
+	case rd >= len(AmrNbMcMagic) && string(buf[:len(AmrNbMcMagic)]) == AmrNbMcMagic:
+		id3.Codec = AfmtAmrNb
+		headerLen = len(AmrNbMcMagic)
+		multiChannel = true
+	case rd >= len(AmrWbMcMagic) && string(buf[:len(AmrWbMcMagic)]) == AmrWbMcMagic:
+		id3.Codec = AfmtAmrWb
+		headerLen = len(AmrWbMcMagic)
+		multiChannel = true
+	default:
+		return errors.New("not an amr file")
+	}
+
+	if id3.Codec == AfmtAmrWb {
+		frameSizes, bitrates = &AmrWbFrameSizes, &AmrWbBitrates
+		id3.Frequency = 16000
+	} else {
+		frameSizes, bitrates = &AmrNbFrameSizes, &AmrNbBitrates
+		id3.Frequency = 8000
+	}
+
+	id3.Channels = 1
+	if multiChannel {
+		// 32 bit channel description, the lowest 4 bits hold the number
+		// of channels
+		if _, err := fd.Seek(int64(headerLen), io.SeekStart); err != nil {
+			return errors.Wrap(err, 0)
+		}
+
+		desc, rd, err := ReadUint32be(fd)
+		if err != nil {
+			return err
+		} else if rd < 4 {
+			return errors.New("failed to read amr channel description")
+		}
+
+		id3.Channels = uint(desc & 0xF)
+		if id3.Channels == 0 {
+			return errors.New("amr file has no channels")
+		}
+
+		headerLen += 4
+	}
+
+	id3.FirstFrameOffset = int64(headerLen)
+	if _, err := fd.Seek(id3.FirstFrameOffset, io.SeekStart); err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	// Walk the frame headers. A multichannel frame block holds one frame per
+	// channel, all covering the same 20ms.
+	var frames, bitSum uint64
+	for done := false; !done; {
+		for c := uint(0); c < id3.Channels; c++ {
+			if rd, err := fd.Read(buf[:1]); err == io.EOF || (err == nil && rd < 1) {
+				done = true
+				break
+			} else if err != nil {
+				return errors.Wrap(err, 0)
+			}
+
+			frameType := (buf[0] >> 3) & 0xF
+			bitSum += uint64(bitrates[frameType])
+
+			if _, err := fd.Seek(int64(frameSizes[frameType]), io.SeekCurrent); err != nil {
+				return errors.Wrap(err, 0)
+			}
+		}
+
+		if !done {
+			frames++
+		}
+	}
+
+	if frames == 0 {
+		return errors.New("amr file has no frames")
+	}
+
+	id3.VBR = true
+	id3.Filesize = fd.FileSize()
+	id3.FrameCount = frames
+	id3.Samples = frames * id3.Frequency * AmrFrameMs / 1000
+	id3.Length = frames * AmrFrameMs
+	// Average over all frames, summed up for all channels, in kbit/s
+	id3.Bitrate = int(bitSum / frames / 1000)
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package metadata
+
+import (
+	"bytes"
+	"testing"
+)
+
+// Build an AMR stream from a header and the frame types of its frames
+func amrFile(header string, sizes *[16]int, types ...byte) []byte {
+	var b bytes.Buffer
+	b.WriteString(header)
+	for _, typ := range types {
+		b.WriteByte(typ<<3 | 0x04)
+		b.Write(make([]byte, sizes[typ]))
+	}
+	return b.Bytes()
+}
+
+func TestGetAmrMetadata(t *testing.T) {
+	repeat := func(typ byte, n int) []byte {
+		return bytes.Repeat([]byte{typ}, n)
+	}
+
+	tests := []struct {
+		name        string
+		data        []byte
+		wantCodec   CodecType
+		wantFrames  uint64
+		wantLength  uint64
+		wantBitrate int
+	}{
+		{"nb 12.2k", amrFile(AmrNbMagic, &AmrNbFrameSizes, repeat(7, 50)...), AfmtAmrNb, 50, 1000, 12},
+		{"nb 4.75k", amrFile(AmrNbMagic, &AmrNbFrameSizes, repeat(0, 10)...), AfmtAmrNb, 10, 200, 4},
+		{"nb with no data frames", amrFile(AmrNbMagic, &AmrNbFrameSizes, 7, 15, 7, 15), AfmtAmrNb, 4, 80, 6},
+		{"wb 23.85k", amrFile(AmrWbMagic, &AmrWbFrameSizes, repeat(8, 25)...), AfmtAmrWb, 25, 500, 23},
+		{"wb with comfort noise", amrFile(AmrWbMagic, &AmrWbFrameSizes, 2, 9), AfmtAmrWb, 2, 40, 7},
+		{"nb two channels", amrFile(AmrNbMcMagic+"\x00\x00\x00\x02", &AmrNbFrameSizes, repeat(7, 20)...), AfmtAmrNb, 10, 200, 24},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var id3 Mp3Entry
+			if err := GetAmrMetadata(openTestFile(t, "test.amr", tt.data), &id3); err != nil {
+				t.Fatal(err)
+			}
+			if id3.Codec != tt.wantCodec {
+				t.Errorf("Codec = %v, want %v", id3.Codec, tt.wantCodec)
+			}
+			if id3.FrameCount != tt.wantFrames {
+				t.Errorf("FrameCount = %d, want %d", id3.FrameCount, tt.wantFrames)
+			}
+			if id3.Length != tt.wantLength {
+				t.Errorf("Length = %d, want %d", id3.Length, tt.wantLength)
+			}
+			if id3.Bitrate != tt.wantBitrate {
+				t.Errorf("Bitrate = %d, want %d", id3.Bitrate, tt.wantBitrate)
+			}
+		})
+	}
+}
+
+func TestGetAmrMetadataErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"no magic", []byte("#!AMX\nabcdefgh")},
+		{"no frames", []byte(AmrNbMagic)},
+		{"no channels", []byte(AmrWbMcMagic + "\x00\x00\x00\x00")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var id3 Mp3Entry
+			if err := GetAmrMetadata(openTestFile(t, "test.amr", tt.data), &id3); err == nil {
+				t.Error("GetAmrMetadata() succeeded")
+			}
+		})
+	}
+}
@@ -1,51 +1,172 @@
 package metadata
 
 import (
+	"bytes"
 	"github.com/go-errors/errors"
 	"io"
 	"rbmetadata-go/tools"
+	"strings"
 )
 
 const (
-	ApeTagHeaderLength = 32
-	ApeTagHeaderFormat = "8llll8"
+	ApeTagHeaderLength     = 32
+	ApeTagHeaderFormat     = "8llll8"
 	ApeTagItemHeaderFormat = "ll"
-	ApeTagItemTypeMask = 3
+	ApeTagItemTypeMask     = 3
+	// Set in the footer if the tag starts with a header
+	ApeTagHasHeader = 1 << 31
+	// Item types
+	ApeTagItemText   = 0
+	ApeTagItemBinary = 1
 )
 
 type ApeTagHeader struct {
-	Id [8]byte
-	Version uint32
-	Length uint32
+	Id        [8]byte
+	Version   uint32
+	Length    uint32
 	ItemCount uint32
-	Flags uint32
-	Reserved [8]byte
+	Flags     uint32
+	Reserved  [8]byte
 }
 
 type ApeTagItemHeader struct {
 	Length int32
-	Flags uint32
+	Flags  uint32
 }
 
-// Read the items in an APEV2 tag. Only looks for a tag at the end of a
-// file. Returns true if a tag was found and fully read, false otherwise.
+// Read the items in an APEV2 tag. Looks for a tag at the end of a file, in
+// front of an ID3v1 tag, or in front of the Lyrics3 tag before it. Returns
+// nil if there is no tag. The length of the tag is stored in id3.ApeLen.
+//
+// id3.Id3v1len and id3.Lyrics3len must already be set, see ReadLyrics3Tag.
 func ReadApeTags(fd *tools.File, id3 *Mp3Entry) error {
+	id3.ApeLen = 0
+
+	var buf [ApeTagHeaderLength]byte
 	var header ApeTagHeader
 
-	_, err := fd.Seek(-ApeTagHeaderLength, io.SeekEnd)
-	if err != nil {
-		return err
+	// read header (the footer, actually)
+	end := int64(fd.FileSize())
+	found := false
+	tagEnds := []int64{end, end - 128}
+	if id3.Lyrics3len > 0 {
+		tagEnds = append(tagEnds, end-int64(id3.Id3v1len)-int64(id3.Lyrics3len))
+	}
+	for _, tagEnd := range tagEnds {
+		if tagEnd < ApeTagHeaderLength {
+			continue
+		}
+
+		if _, err := fd.Seek(tagEnd-ApeTagHeaderLength, io.SeekStart); err != nil {
+			return errors.Wrap(err, 0)
+		}
+
+		if rd, err := fd.Read(buf[:]); err != nil {
+			return errors.Wrap(err, 0)
+		} else if rd < len(buf) {
+			return errors.New("failed to read ape tag header")
+		}
+
+		if string(buf[:8]) == "APETAGEX" {
+			end = tagEnd
+			found = true
+			break
+		}
 	}
 
-	// read header
+	if !found {
+		return nil
+	}
+
+	copy(header.Id[:], buf[:8])
+	header.Version = GetLongLE(buf[8:])
+	header.Length = GetLongLE(buf[12:])
+	header.ItemCount = GetLongLE(buf[16:])
+	header.Flags = GetLongLE(buf[20:])
+	copy(header.Reserved[:], buf[24:])
 
-	if string(header.Id[:8]) != "APETAGEX" {
-		return errors.New("not in ape tag format")
+	if header.Version != 2000 || header.ItemCount == 0 || header.Length <= ApeTagHeaderLength || int64(header.Length) > end {
+		return nil
 	}
 
-	if header.Version == 2000 && header.ItemCount > 0 && header.Length > ApeTagHeaderLength {
+	// The length includes the items and the footer, but not the header
+	id3.ApeLen = uint64(header.Length)
+	if header.Flags&ApeTagHasHeader != 0 {
+		id3.ApeLen += ApeTagHeaderLength
+	}
+	itemsPos := end - int64(header.Length)
+	if _, err := fd.Seek(itemsPos, io.SeekStart); err != nil {
+		return errors.Wrap(err, 0)
+	}
 
+	items := make([]byte, header.Length-ApeTagHeaderLength)
+	if rd, err := fd.Read(items); err != nil {
+		return errors.Wrap(err, 0)
+	} else if rd < len(items) {
+		return errors.New("failed to read ape tag items")
+	}
+
+	p := 0
+	for i := uint32(0); i < header.ItemCount && p+8 <= len(items); i++ {
+		item := ApeTagItemHeader{
+			Length: int32(GetLongLE(items[p:])),
+			Flags:  GetLongLE(items[p+4:]),
+		}
+		p += 8
+
+		nameLen := bytes.IndexByte(items[p:], 0)
+		if nameLen < 0 || item.Length < 0 || p+nameLen+1+int(item.Length) > len(items) {
+			break
+		}
+
+		name := string(items[p : p+nameLen])
+		p += nameLen + 1
+		valuePos := itemsPos + int64(p)
+		value := items[p : p+int(item.Length)]
+		p += int(item.Length)
+
+		// Bit 0 is the read only flag, bits 1-2 hold the item type
+		switch (item.Flags >> 1) & ApeTagItemTypeMask {
+		case ApeTagItemText:
+			if tools.Strcasecmp(name, "cuesheet") {
+				id3.HasEmbeddedCueSheet = true
+				id3.EmbeddedCuesheet.Pos = int(valuePos)
+				id3.EmbeddedCuesheet.Size = len(value)
+				id3.EmbeddedCuesheet.Encoding = CharEncUtf8
+				continue
+			}
+
+			// Several values are separated by a nul. A value that can't be
+			// parsed only loses this item.
+			for _, v := range strings.Split(string(value), "\x00") {
+				_ = ParseTag(name, v, id3, TagTypeApe)
+			}
+		case ApeTagItemBinary:
+			if !tools.Strcasecmp(name, "cover art (front)") || id3.HasAlbumArt {
+				continue
+			}
+
+			// The image is preceded by its file name
+			n := bytes.IndexByte(value, 0)
+			if n < 0 {
+				continue
+			}
+			image := value[n+1:]
+
+			id3.AlbumArt = Mp3AlbumArt{TypeAA: AaTypeUnknown}
+			if bytes.HasPrefix(image, []byte("\xff\xd8")) {
+				id3.AlbumArt.TypeAA = AaTypeJpg
+			} else if bytes.HasPrefix(image, []byte("\x89PNG\r\n\x1a\n")) {
+				id3.AlbumArt.TypeAA = AaTypePng
+			}
+
+			if id3.AlbumArt.TypeAA != AaTypeUnknown {
+				id3.AlbumArt.Pos = int(valuePos) + n + 1
+				id3.AlbumArt.Size = len(image)
+				id3.HasAlbumArt = true
+			}
+		}
 	}
 
 	return nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,135 @@
+package metadata
+
+import (
+	"rbmetadata-go/tools"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Add a comment to id3.Comments, unless it is already there, and show the
+// one ChooseComment picks in id3.Comment. A comment set by other means, e.g.
+// from a Lyrics3 INF field, is kept.
+func AddComment(id3 *Mp3Entry, comment Comment) {
+	comment.Text = strings.TrimRightFunc(comment.Text, unicode.IsSpace)
+	if comment.Text == "" {
+		return
+	}
+
+	for _, c := range id3.Comments {
+		if c == comment {
+			return
+		}
+	}
+
+	chosen, _ := ChooseComment(id3.Comments)
+	id3.Comments = append(id3.Comments, comment)
+	if id3.Comment == "" || id3.Comment == chosen.Text {
+		if c, ok := ChooseComment(id3.Comments); ok {
+			id3.Comment = c.Text
+		}
+	}
+}
+
+// Choose the comment to show to the user: the first one without a
+// description, else the first one with a description. The "ID3v1 Comment"
+// copy some taggers write is only taken if there is no other comment, and
+// the comments other programs keep their data in (see IsTechnicalComment)
+// never. Returns false if there is no comment to show.
+func ChooseComment(comments []Comment) (Comment, bool) {
+	best, bestRank := Comment{}, commentRankNone
+	for _, c := range comments {
+		if rank := commentRank(c); rank < bestRank {
+			best, bestRank = c, rank
+		}
+	}
+	return best, bestRank < commentRankNone
+}
+
+const (
+	commentRankPlain = iota
+	commentRankDescribed
+	commentRankId3v1
+	commentRankNone
+)
+
+func commentRank(comment Comment) int {
+	switch {
+	case comment.Description == "":
+		return commentRankPlain
+	case comment.Description == "ID3v1 Comment":
+		return commentRankId3v1
+	case IsTechnicalComment(comment.Description):
+		return commentRankNone
+	}
+	return commentRankDescribed
+}
+
+// Check if the description of a comment marks it as data of another
+// program, like the iTunes gapless information, rather than text to show.
+func IsTechnicalComment(description string) bool {
+	for _, prefix := range []string{"iTun", "Songs-DB", "MusicMatch"} {
+		if strings.HasPrefix(description, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Get the text of the first comment with the given description, and the
+// given language unless that is "". Only ID3 comments have a language.
+// Returns false if there is no such comment.
+func (id3 *Mp3Entry) GetComment(language, description string) (string, bool) {
+	for _, c := range id3.Comments {
+		if c.Description == description && (language == "" || strings.EqualFold(c.Language, language)) {
+			return c.Text, true
+		}
+	}
+	return "", false
+}
+
+// Parse the iTunes technical comments iTunNORM, iTunSMPB and iTunPGAP into
+// id3.ITunes. They are ID3 comments or MP4 freeform atoms. Returns false for
+// other names.
+func ParseITunesComment(name, value string, id3 *Mp3Entry) bool {
+	switch {
+	case tools.Strcasecmp(name, "iTunNORM"):
+		id3.ITunes.Normalization = nil
+		for _, v := range parseITunesHex(value) {
+			id3.ITunes.Normalization = append(id3.ITunes.Normalization, uint32(v))
+		}
+	case tools.Strcasecmp(name, "iTunSMPB"):
+		// A zero, the encoder delay, the padding and the number of samples
+		// without them, followed by values of unknown meaning
+		v := parseITunesHex(value)
+		if len(v) < 3 {
+			return true
+		}
+		id3.ITunes.EncoderDelay = int(v[1])
+		id3.ITunes.EncoderPadding = int(v[2])
+		if len(v) > 3 {
+			id3.ITunes.SampleCount = int64(v[3])
+		}
+		id3.LeadTrim = id3.ITunes.EncoderDelay
+		id3.TailTrim = id3.ITunes.EncoderPadding
+	case tools.Strcasecmp(name, "iTunPGAP"):
+		id3.ITunes.Gapless = ParseTagBool(strings.TrimSpace(value))
+	default:
+		return false
+	}
+
+	return true
+}
+
+// Parse the space separated hexadecimal numbers of an iTunes comment, up to
+// the first one that isn't.
+func parseITunesHex(value string) (values []uint64) {
+	for _, word := range strings.Fields(value) {
+		v, err := strconv.ParseUint(word, 16, 64)
+		if err != nil {
+			break
+		}
+		values = append(values, v)
+	}
+	return
+}
@@ -0,0 +1,138 @@
+package metadata
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Parse a date from a tag. Dates are mostly given in (a subset of) ISO 8601,
+// "yyyy-MM-ddTHH:mm:ss", cut off after any of its parts. The separators may
+// also be '/' and ' ', and anything after the last part that could be
+// parsed, e.g. a time zone, is ignored. Values not starting with a date, e.g.
+// "circa 1765", use the first four digit number as the year.
+func ParseTagDate(value string) (TagDate, bool) {
+	var date TagDate
+
+	s := strings.TrimSpace(value)
+	num := func(n int) (int, bool) {
+		if len(s) < n {
+			return 0, false
+		}
+		for i := 0; i < n; i++ {
+			if s[i] < '0' || s[i] > '9' {
+				return 0, false
+			}
+		}
+		v, _ := strconv.Atoi(s[:n])
+		s = s[n:]
+		return v, true
+	}
+	sep := func(seps string) bool {
+		if len(s) > 1 && strings.IndexByte(seps, s[0]) >= 0 {
+			s = s[1:]
+			return true
+		}
+		return false
+	}
+
+	var ok bool
+	if date.Year, ok = num(4); !ok {
+		return yearInText(value)
+	}
+	date.Precision = DatePrecisionYear
+
+	parts := []struct {
+		seps     string
+		v        *int
+		min, max int
+	}{
+		{"-/", &date.Month, 1, 12},
+		{"-/", &date.Day, 1, 31},
+		{"T ", &date.Hour, 0, 23},
+		{":", &date.Minute, 0, 59},
+		{":", &date.Second, 0, 60},
+	}
+	for _, part := range parts {
+		if !sep(part.seps) {
+			break
+		}
+		v, ok := num(2)
+		if !ok || v < part.min || v > part.max {
+			*part.v = 0
+			break
+		}
+		*part.v = v
+		date.Precision++
+	}
+
+	return date, true
+}
+
+// Find the first four digit number in a free form date
+func yearInText(value string) (TagDate, bool) {
+	run := 0
+	for i := 0; i <= len(value); i++ {
+		if i < len(value) && value[i] >= '0' && value[i] <= '9' {
+			run++
+			continue
+		}
+		if run == 4 {
+			year, _ := strconv.Atoi(value[i-4 : i])
+			return TagDate{Year: year, Precision: DatePrecisionYear}, true
+		}
+		run = 0
+	}
+	return TagDate{}, false
+}
+
+// Format the date in ISO 8601, up to its precision
+func (d TagDate) String() string {
+	switch d.Precision {
+	case DatePrecisionYear:
+		return fmt.Sprintf("%04d", d.Year)
+	case DatePrecisionMonth:
+		return fmt.Sprintf("%04d-%02d", d.Year, d.Month)
+	case DatePrecisionDay:
+		return fmt.Sprintf("%04d-%02d-%02d", d.Year, d.Month, d.Day)
+	case DatePrecisionHour:
+		return fmt.Sprintf("%04d-%02d-%02dT%02d", d.Year, d.Month, d.Day, d.Hour)
+	case DatePrecisionMinute:
+		return fmt.Sprintf("%04d-%02d-%02dT%02d:%02d", d.Year, d.Month, d.Day, d.Hour, d.Minute)
+	case DatePrecisionSecond:
+		return fmt.Sprintf("%04d-%02d-%02dT%02d:%02d:%02d", d.Year, d.Month, d.Day, d.Hour, d.Minute, d.Second)
+	}
+	return ""
+}
+
+// Set the release date of id3 from value, unless it already has one. The
+// year is also used for id3.Year if that is missing, unless it is before
+// 1900, which is not likely. Returns false if value holds no date.
+func SetReleaseDate(id3 *Mp3Entry, value string) bool {
+	date, ok := ParseTagDate(value)
+	if !ok {
+		return false
+	}
+
+	if id3.ReleaseDate.Precision == DatePrecisionNone {
+		id3.ReleaseDate = date
+	}
+	if id3.Year == 0 && date.Year >= 1900 {
+		id3.Year = date.Year
+	}
+	return true
+}
+
+// Set the original release date of id3 from value, unless it already has
+// one. Returns false if value holds no date.
+func SetOriginalDate(id3 *Mp3Entry, value string) bool {
+	date, ok := ParseTagDate(value)
+	if !ok {
+		return false
+	}
+
+	if id3.OriginalDate.Precision == DatePrecisionNone {
+		id3.OriginalDate = date
+	}
+	return true
+}
@@ -0,0 +1,63 @@
+package metadata
+
+import "testing"
+
+func TestParseTagDate(t *testing.T) {
+	tests := []struct {
+		value  string
+		want   TagDate
+		wantOk bool
+	}{
+		{"2004", TagDate{Year: 2004, Precision: DatePrecisionYear}, true},
+		{"2004-05", TagDate{Year: 2004, Month: 5, Precision: DatePrecisionMonth}, true},
+		{"2004/05/17", TagDate{Year: 2004, Month: 5, Day: 17, Precision: DatePrecisionDay}, true},
+		{"2004-05-17T13", TagDate{Year: 2004, Month: 5, Day: 17, Hour: 13, Precision: DatePrecisionHour}, true},
+		{"2004-05-17 13:45", TagDate{Year: 2004, Month: 5, Day: 17, Hour: 13, Minute: 45, Precision: DatePrecisionMinute}, true},
+		{"2004-05-17T13:45:30Z", TagDate{Year: 2004, Month: 5, Day: 17, Hour: 13, Minute: 45, Second: 30, Precision: DatePrecisionSecond}, true},
+		{" 1999-12-31 ", TagDate{Year: 1999, Month: 12, Day: 31, Precision: DatePrecisionDay}, true},
+		{"2004-13-01", TagDate{Year: 2004, Precision: DatePrecisionYear}, true},
+		{"2004-05-32", TagDate{Year: 2004, Month: 5, Precision: DatePrecisionMonth}, true},
+		{"2004-5-17", TagDate{Year: 2004, Precision: DatePrecisionYear}, true},
+		{"2004-", TagDate{Year: 2004, Precision: DatePrecisionYear}, true},
+		{"circa 1765", TagDate{Year: 1765, Precision: DatePrecisionYear}, true},
+		{"17th of May, 2004", TagDate{Year: 2004, Precision: DatePrecisionYear}, true},
+		{"12345", TagDate{Year: 1234, Precision: DatePrecisionYear}, true},
+		{"no 12345 date", TagDate{}, false},
+		{"05/17/04", TagDate{}, false},
+		{"", TagDate{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, ok := ParseTagDate(tt.value)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("ParseTagDate(%q) = %+v, %v, want %+v, %v", tt.value, got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestTagDateString(t *testing.T) {
+	tests := []string{
+		"0999",
+		"2004",
+		"2004-05",
+		"2004-05-17",
+		"2004-05-17T13",
+		"2004-05-17T13:45",
+		"2004-05-17T13:45:30",
+	}
+
+	for _, value := range tests {
+		t.Run(value, func(t *testing.T) {
+			date, _ := ParseTagDate(value)
+			if got := date.String(); got != value {
+				t.Errorf("ParseTagDate(%q).String() = %q", value, got)
+			}
+		})
+	}
+
+	if got := (TagDate{}).String(); got != "" {
+		t.Errorf("TagDate{}.String() = %q, want \"\"", got)
+	}
+}
@@ -0,0 +1,671 @@
+package metadata
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Canonical name of a tag field, the same for all tag formats. The names
+// follow the Vorbis comment names, in lower case.
+type Field string
+
+const (
+	FieldTitle       Field = "title"
+	FieldArtist      Field = "artist"
+	FieldAlbum       Field = "album"
+	FieldAlbumArtist Field = "albumartist"
+	FieldComposer    Field = "composer"
+	FieldGenre       Field = "genre"
+	FieldGrouping    Field = "grouping"
+	FieldComment     Field = "comment"
+	FieldLyrics      Field = "lyrics"
+
+	FieldDate         Field = "date"
+	FieldOriginalDate Field = "originaldate"
+	FieldTrackNumber  Field = "tracknumber"
+	FieldTrackTotal   Field = "tracktotal"
+	FieldDiscNumber   Field = "discnumber"
+	FieldDiscTotal    Field = "disctotal"
+
+	FieldRating    Field = "rating"
+	FieldPlayCount Field = "playcount"
+
+	// The track id is the id of the recording, the release track id the
+	// one of the track on a specific release
+	FieldMusicBrainzTrackId        Field = "musicbrainz_trackid"
+	FieldMusicBrainzReleaseTrackId Field = "musicbrainz_releasetrackid"
+	FieldMusicBrainzAlbumId        Field = "musicbrainz_albumid"
+	FieldMusicBrainzReleaseGroupId Field = "musicbrainz_releasegroupid"
+	FieldMusicBrainzArtistId       Field = "musicbrainz_artistid"
+	FieldMusicBrainzAlbumArtistId  Field = "musicbrainz_albumartistid"
+	FieldMusicBrainzWorkId         Field = "musicbrainz_workid"
+	FieldMusicBrainzDiscId         Field = "musicbrainz_discid"
+
+	FieldArtistSort      Field = "artistsort"
+	FieldAlbumSort       Field = "albumsort"
+	FieldTitleSort       Field = "titlesort"
+	FieldAlbumArtistSort Field = "albumartistsort"
+	FieldComposerSort    Field = "composersort"
+
+	FieldBpm             Field = "bpm"
+	FieldIsrc            Field = "isrc"
+	FieldLabel           Field = "label"
+	FieldCopyright       Field = "copyright"
+	FieldEncodedBy       Field = "encodedby"
+	FieldEncoderSettings Field = "encodersettings"
+	FieldCompilation     Field = "compilation"
+	FieldMood            Field = "mood"
+	FieldKey             Field = "key"
+	FieldMedia           Field = "media"
+	FieldBarcode         Field = "barcode"
+	FieldCatalogNumber   Field = "catalognumber"
+
+	FieldMediaKind       Field = "mediakind"
+	FieldPodcast         Field = "podcast"
+	FieldPodcastUrl      Field = "podcasturl"
+	FieldEpisodeGuid     Field = "episodeguid"
+	FieldDescription     Field = "description"
+	FieldLongDescription Field = "longdescription"
+	FieldCategory        Field = "category"
+	FieldKeywords        Field = "keywords"
+	FieldShow            Field = "show"
+	FieldNarrator        Field = "narrator"
+
+	FieldWork           Field = "work"
+	FieldMovementName   Field = "movementname"
+	FieldMovementNumber Field = "movementnumber"
+	FieldMovementTotal  Field = "movementtotal"
+	FieldConductor      Field = "conductor"
+	FieldEnsemble       Field = "ensemble"
+
+	// The roles of Credit
+	FieldPerformer Field = "performer"
+	FieldProducer  Field = "producer"
+	FieldEngineer  Field = "engineer"
+	FieldMixer     Field = "mixer"
+	FieldArranger  Field = "arranger"
+	FieldLyricist  Field = "lyricist"
+	FieldRemixer   Field = "remixer"
+
+	FieldReplayGainTrackGain Field = "replaygain_track_gain"
+	FieldReplayGainTrackPeak Field = "replaygain_track_peak"
+	FieldReplayGainAlbumGain Field = "replaygain_album_gain"
+	FieldReplayGainAlbumPeak Field = "replaygain_album_peak"
+)
+
+// The names of a field in each tag format, as written by taggers. An empty
+// name means the format has no common way to store the field.
+//
+// ID3 user defined text frames are named "TXXX:<description>" and unique
+// file identifiers "UFID:<owner>". MP4 names are the atom names, with '©'
+// as the single byte 0xa9 like in the file, and "----:<mean>:<name>" for
+// freeform atoms. Several fields may share a name when one value holds
+// them all, like the track number and total in "3/12".
+type FieldNames struct {
+	Field                  Field
+	Id3v22, Id3v23, Id3v24 string
+	Vorbis, Ape, Mp4       string
+}
+
+// Prefix of the names of iTunes' freeform atoms
+const Mp4FreeformPrefix = "----:com.apple.iTunes:"
+
+var FieldTable = []FieldNames{
+	{FieldTitle, "TT2", "TIT2", "TIT2", "TITLE", "Title", "\xa9nam"},
+	{FieldArtist, "TP1", "TPE1", "TPE1", "ARTIST", "Artist", "\xa9ART"},
+	{FieldAlbum, "TAL", "TALB", "TALB", "ALBUM", "Album", "\xa9alb"},
+	{FieldAlbumArtist, "TP2", "TPE2", "TPE2", "ALBUMARTIST", "Album Artist", "aART"},
+	{FieldComposer, "TCM", "TCOM", "TCOM", "COMPOSER", "Composer", "\xa9wrt"},
+	{FieldGenre, "TCO", "TCON", "TCON", "GENRE", "Genre", "\xa9gen"},
+	{FieldGrouping, "TT1", "TIT1", "TIT1", "GROUPING", "Grouping", "\xa9grp"},
+	{FieldComment, "COM", "COMM", "COMM", "COMMENT", "Comment", "\xa9cmt"},
+	{FieldLyrics, "ULT", "USLT", "USLT", "LYRICS", "Lyrics", "\xa9lyr"},
+
+	{FieldDate, "TYE", "TYER", "TDRC", "DATE", "Year", "\xa9day"},
+	{FieldOriginalDate, "TOR", "TORY", "TDOR", "ORIGINALDATE", "Original Date", Mp4FreeformPrefix + "ORIGINALDATE"},
+	{FieldTrackNumber, "TRK", "TRCK", "TRCK", "TRACKNUMBER", "Track", "trkn"},
+	{FieldTrackTotal, "TRK", "TRCK", "TRCK", "TRACKTOTAL", "Track", "trkn"},
+	{FieldDiscNumber, "TPA", "TPOS", "TPOS", "DISCNUMBER", "Disc", "disk"},
+	{FieldDiscTotal, "TPA", "TPOS", "TPOS", "DISCTOTAL", "Disc", "disk"},
+
+	{FieldRating, "POP", "POPM", "POPM", "FMPS_RATING", "FMPS_RATING", Mp4FreeformPrefix + "rate"},
+	{FieldPlayCount, "CNT", "PCNT", "PCNT", "FMPS_PLAYCOUNT", "FMPS_PLAYCOUNT", ""},
+
+	{FieldMusicBrainzTrackId, "UFI:http://musicbrainz.org", "UFID:http://musicbrainz.org", "UFID:http://musicbrainz.org",
+		"MUSICBRAINZ_TRACKID", "MUSICBRAINZ_TRACKID", Mp4FreeformPrefix + "MusicBrainz Track Id"},
+	{FieldMusicBrainzReleaseTrackId, "TXX:MusicBrainz Release Track Id", "TXXX:MusicBrainz Release Track Id", "TXXX:MusicBrainz Release Track Id",
+		"MUSICBRAINZ_RELEASETRACKID", "MUSICBRAINZ_RELEASETRACKID", Mp4FreeformPrefix + "MusicBrainz Release Track Id"},
+	{FieldMusicBrainzAlbumId, "TXX:MusicBrainz Album Id", "TXXX:MusicBrainz Album Id", "TXXX:MusicBrainz Album Id",
+		"MUSICBRAINZ_ALBUMID", "MUSICBRAINZ_ALBUMID", Mp4FreeformPrefix + "MusicBrainz Album Id"},
+	{FieldMusicBrainzReleaseGroupId, "TXX:MusicBrainz Release Group Id", "TXXX:MusicBrainz Release Group Id", "TXXX:MusicBrainz Release Group Id",
+		"MUSICBRAINZ_RELEASEGROUPID", "MUSICBRAINZ_RELEASEGROUPID", Mp4FreeformPrefix + "MusicBrainz Release Group Id"},
+	{FieldMusicBrainzArtistId, "TXX:MusicBrainz Artist Id", "TXXX:MusicBrainz Artist Id", "TXXX:MusicBrainz Artist Id",
+		"MUSICBRAINZ_ARTISTID", "MUSICBRAINZ_ARTISTID", Mp4FreeformPrefix + "MusicBrainz Artist Id"},
+	{FieldMusicBrainzAlbumArtistId, "TXX:MusicBrainz Album Artist Id", "TXXX:MusicBrainz Album Artist Id", "TXXX:MusicBrainz Album Artist Id",
+		"MUSICBRAINZ_ALBUMARTISTID", "MUSICBRAINZ_ALBUMARTISTID", Mp4FreeformPrefix + "MusicBrainz Album Artist Id"},
+	{FieldMusicBrainzWorkId, "TXX:MusicBrainz Work Id", "TXXX:MusicBrainz Work Id", "TXXX:MusicBrainz Work Id",
+		"MUSICBRAINZ_WORKID", "MUSICBRAINZ_WORKID", Mp4FreeformPrefix + "MusicBrainz Work Id"},
+	{FieldMusicBrainzDiscId, "TXX:MusicBrainz Disc Id", "TXXX:MusicBrainz Disc Id", "TXXX:MusicBrainz Disc Id",
+		"MUSICBRAINZ_DISCID", "MUSICBRAINZ_DISCID", Mp4FreeformPrefix + "MusicBrainz Disc Id"},
+
+	// ID3v2.3 has no sort order frames, XSO* is what taggers use instead
+	{FieldArtistSort, "TSP", "XSOP", "TSOP", "ARTISTSORT", "ArtistSort", "soar"},
+	{FieldAlbumSort, "TSA", "XSOA", "TSOA", "ALBUMSORT", "AlbumSort", "soal"},
+	{FieldTitleSort, "TST", "XSOT", "TSOT", "TITLESORT", "TitleSort", "sonm"},
+	{FieldAlbumArtistSort, "TS2", "TSO2", "TSO2", "ALBUMARTISTSORT", "AlbumArtistSort", "soaa"},
+	{FieldComposerSort, "TSC", "TSOC", "TSOC", "COMPOSERSORT", "ComposerSort", "soco"},
+
+	{FieldBpm, "TBP", "TBPM", "TBPM", "BPM", "BPM", "tmpo"},
+	{FieldIsrc, "TRC", "TSRC", "TSRC", "ISRC", "ISRC", Mp4FreeformPrefix + "ISRC"},
+	{FieldLabel, "TPB", "TPUB", "TPUB", "LABEL", "Label", Mp4FreeformPrefix + "LABEL"},
+	{FieldCopyright, "TCR", "TCOP", "TCOP", "COPYRIGHT", "Copyright", "cprt"},
+	{FieldEncodedBy, "TEN", "TENC", "TENC", "ENCODEDBY", "EncodedBy", "\xa9enc"},
+	{FieldEncoderSettings, "TSS", "TSSE", "TSSE", "ENCODERSETTINGS", "EncoderSettings", "\xa9too"},
+	{FieldCompilation, "TCP", "TCMP", "TCMP", "COMPILATION", "Compilation", "cpil"},
+	{FieldMood, "TXX:MOOD", "TXXX:MOOD", "TMOO", "MOOD", "Mood", Mp4FreeformPrefix + "MOOD"},
+	{FieldKey, "TKE", "TKEY", "TKEY", "KEY", "Key", Mp4FreeformPrefix + "initialkey"},
+	{FieldMedia, "TMT", "TMED", "TMED", "MEDIA", "Media", Mp4FreeformPrefix + "MEDIA"},
+	{FieldBarcode, "TXX:BARCODE", "TXXX:BARCODE", "TXXX:BARCODE", "BARCODE", "Barcode", Mp4FreeformPrefix + "BARCODE"},
+	{FieldCatalogNumber, "TXX:CATALOGNUMBER", "TXXX:CATALOGNUMBER", "TXXX:CATALOGNUMBER", "CATALOGNUMBER", "CatalogNumber", Mp4FreeformPrefix + "CATALOGNUMBER"},
+
+	// Podcast frames and atoms of iTunes
+	{FieldMediaKind, "", "", "", "", "", "stik"},
+	{FieldPodcast, "PCS", "PCST", "PCST", "", "", "pcst"},
+	{FieldPodcastUrl, "WFD", "WFED", "WFED", "", "", "purl"},
+	{FieldEpisodeGuid, "TID", "TGID", "TGID", "", "", "egid"},
+	{FieldDescription, "TDS", "TDES", "TDES", "", "", "desc"},
+	{FieldLongDescription, "", "", "", "", "", "ldes"},
+	{FieldCategory, "TCT", "TCAT", "TCAT", "", "", "catg"},
+	{FieldKeywords, "TKW", "TKWD", "TKWD", "", "", "keyw"},
+	{FieldShow, "", "", "", "", "", "tvsh"},
+	{FieldNarrator, "", "", "", "NARRATOR", "Narrator", "\xa9nrt"},
+
+	{FieldWork, "TXX:WORK", "TXXX:WORK", "TXXX:WORK", "WORK", "Work", "\xa9wrk"},
+	{FieldMovementName, "MVN", "MVNM", "MVNM", "MOVEMENTNAME", "MovementName", "\xa9mvn"},
+	{FieldMovementNumber, "MVI", "MVIN", "MVIN", "MOVEMENT", "Movement", "\xa9mvi"},
+	{FieldMovementTotal, "MVI", "MVIN", "MVIN", "MOVEMENTTOTAL", "MovementTotal", "\xa9mvc"},
+	{FieldConductor, "TP3", "TPE3", "TPE3", "CONDUCTOR", "Conductor", Mp4FreeformPrefix + "CONDUCTOR"},
+	// ID3 files use TPE2, which is the album artist as well, see SetId3v2Work
+	{FieldEnsemble, "TXX:ENSEMBLE", "TXXX:ENSEMBLE", "TXXX:ENSEMBLE", "ENSEMBLE", "Ensemble", Mp4FreeformPrefix + "ENSEMBLE"},
+
+	// ID3 lists musicians with their instrument in TMCL, and the other
+	// people with their role in TIPL, see ParseCredits. Vorbis comments give
+	// musicians as "Name (instrument)".
+	{FieldPerformer, "IPL", "IPLS", "TMCL", "PERFORMER", "Performer", ""},
+	{FieldProducer, "IPL", "IPLS", "TIPL", "PRODUCER", "Producer", Mp4FreeformPrefix + "PRODUCER"},
+	{FieldEngineer, "IPL", "IPLS", "TIPL", "ENGINEER", "Engineer", Mp4FreeformPrefix + "ENGINEER"},
+	{FieldMixer, "IPL", "IPLS", "TIPL", "MIXER", "Mixer", Mp4FreeformPrefix + "MIXER"},
+	{FieldArranger, "IPL", "IPLS", "TIPL", "ARRANGER", "Arranger", Mp4FreeformPrefix + "ARRANGER"},
+	{FieldLyricist, "TXT", "TEXT", "TEXT", "LYRICIST", "Lyricist", Mp4FreeformPrefix + "LYRICIST"},
+	{FieldRemixer, "TP4", "TPE4", "TPE4", "REMIXER", "MixArtist", Mp4FreeformPrefix + "REMIXER"},
+
+	{FieldReplayGainTrackGain, "TXX:REPLAYGAIN_TRACK_GAIN", "TXXX:REPLAYGAIN_TRACK_GAIN", "TXXX:REPLAYGAIN_TRACK_GAIN",
+		"REPLAYGAIN_TRACK_GAIN", "REPLAYGAIN_TRACK_GAIN", Mp4FreeformPrefix + "replaygain_track_gain"},
+	{FieldReplayGainTrackPeak, "TXX:REPLAYGAIN_TRACK_PEAK", "TXXX:REPLAYGAIN_TRACK_PEAK", "TXXX:REPLAYGAIN_TRACK_PEAK",
+		"REPLAYGAIN_TRACK_PEAK", "REPLAYGAIN_TRACK_PEAK", Mp4FreeformPrefix + "replaygain_track_peak"},
+	{FieldReplayGainAlbumGain, "TXX:REPLAYGAIN_ALBUM_GAIN", "TXXX:REPLAYGAIN_ALBUM_GAIN", "TXXX:REPLAYGAIN_ALBUM_GAIN",
+		"REPLAYGAIN_ALBUM_GAIN", "REPLAYGAIN_ALBUM_GAIN", Mp4FreeformPrefix + "replaygain_album_gain"},
+	{FieldReplayGainAlbumPeak, "TXX:REPLAYGAIN_ALBUM_PEAK", "TXXX:REPLAYGAIN_ALBUM_PEAK", "TXXX:REPLAYGAIN_ALBUM_PEAK",
+		"REPLAYGAIN_ALBUM_PEAK", "REPLAYGAIN_ALBUM_PEAK", Mp4FreeformPrefix + "replaygain_album_peak"},
+}
+
+// Other names of the fields that are understood when reading tags. ID3
+// frames are looked up by their ID3v2.4 names, see NormalizeId3FrameId.
+var FieldAliases = map[TagType]map[string]Field{
+	TagTypeId3v24: {
+		"TXXX:ALBUM ARTIST":   FieldAlbumArtist,
+		"GRP1":                FieldGrouping,
+		"TXXX:FMPS_Rating":    FieldRating,
+		"TXXX:RATING":         FieldRating,
+		"TXXX:FMPS_Playcount": FieldPlayCount,
+		"TXXX:ORIGINALDATE":   FieldOriginalDate,
+	},
+	TagTypeVorbis: {
+		"ALBUM ARTIST":           FieldAlbumArtist,
+		"DESCRIPTION":            FieldComment,
+		"ORCHESTRA":              FieldEnsemble,
+		"CONTENT GROUP":          FieldGrouping,
+		"CONTENTGROUP":           FieldGrouping,
+		"UNSYNCEDLYRICS":         FieldLyrics,
+		"ORIGINALYEAR":           FieldOriginalDate,
+		"ORIGINAL DATE":          FieldOriginalDate,
+		"ORIGINAL YEAR":          FieldOriginalDate,
+		"TOTALTRACKS":            FieldTrackTotal,
+		"TOTALDISCS":             FieldDiscTotal,
+		"DISC":                   FieldDiscNumber,
+		"RATING":                 FieldRating,
+		"PLAYCOUNT":              FieldPlayCount,
+		"PLAY_COUNT":             FieldPlayCount,
+		"HTTP://MUSICBRAINZ.ORG": FieldMusicBrainzTrackId,
+		"ORGANIZATION":           FieldLabel,
+		"PUBLISHER":              FieldLabel,
+		"ENCODED-BY":             FieldEncodedBy,
+		"ENCODER":                FieldEncoderSettings,
+		"INITIALKEY":             FieldKey,
+	},
+	TagTypeApe: {
+		"ALBUMARTIST":            FieldAlbumArtist,
+		"ORCHESTRA":              FieldEnsemble,
+		"CONTENT GROUP":          FieldGrouping,
+		"CONTENTGROUP":           FieldGrouping,
+		"UNSYNCEDLYRICS":         FieldLyrics,
+		"ORIGINALDATE":           FieldOriginalDate,
+		"ORIGINALYEAR":           FieldOriginalDate,
+		"ORIGINAL YEAR":          FieldOriginalDate,
+		"DISCNUMBER":             FieldDiscNumber,
+		"RATING":                 FieldRating,
+		"PLAYCOUNT":              FieldPlayCount,
+		"PLAY_COUNT":             FieldPlayCount,
+		"HTTP://MUSICBRAINZ.ORG": FieldMusicBrainzTrackId,
+		"PUBLISHER":              FieldLabel,
+		"INITIALKEY":             FieldKey,
+	},
+	TagTypeMp4: {
+		"gnre":                              FieldGenre,
+		Mp4FreeformPrefix + "album artist":  FieldAlbumArtist,
+		Mp4FreeformPrefix + "composer":      FieldComposer,
+		Mp4FreeformPrefix + "ORIGINAL YEAR": FieldOriginalDate,
+		Mp4FreeformPrefix + "PUBLISHER":     FieldLabel,
+		Mp4FreeformPrefix + "ENCODEDBY":     FieldEncodedBy,
+	},
+}
+
+// Lower case native names of each tag format, for the lookups
+var fieldsByName = make(map[TagType]map[string]Field)
+
+func init() {
+	add := func(tagType TagType, name string, field Field) {
+		if name == "" {
+			return
+		}
+		names := fieldsByName[tagType]
+		if names == nil {
+			names = make(map[string]Field)
+			fieldsByName[tagType] = names
+		}
+		// When fields share a name, it maps to the first of them
+		if _, ok := names[foldFieldName(name)]; !ok {
+			names[foldFieldName(name)] = field
+		}
+	}
+
+	for _, f := range FieldTable {
+		for _, tagType := range []TagType{TagTypeId3v22, TagTypeId3v23, TagTypeId3v24, TagTypeVorbis, TagTypeApe, TagTypeMp4} {
+			add(tagType, f.Name(tagType), f.Field)
+		}
+	}
+
+	for tagType, aliases := range FieldAliases {
+		for name, field := range aliases {
+			add(tagType, name, field)
+		}
+	}
+}
+
+// Names are compared ignoring the case of ASCII letters only, as MP4 atom
+// names aren't valid UTF-8
+func foldFieldName(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		if c >= 'A' && c <= 'Z' {
+			b[i] = c + 'a' - 'A'
+		}
+	}
+	return string(b)
+}
+
+// The name of the field in the given tag format, "" if there is none
+func (f FieldNames) Name(tagType TagType) string {
+	switch tagType {
+	case TagTypeId3v22:
+		return f.Id3v22
+	case TagTypeId3v23:
+		return f.Id3v23
+	case TagTypeId3v24:
+		return f.Id3v24
+	case TagTypeVorbis:
+		return f.Vorbis
+	case TagTypeApe:
+		return f.Ape
+	case TagTypeMp4:
+		return f.Mp4
+	}
+	return ""
+}
+
+// Get the canonical field of a name used by a tag format, ignoring case.
+// Returns false for names that aren't in FieldTable or FieldAliases.
+func CanonicalField(name string, tagType TagType) (Field, bool) {
+	field, ok := fieldsByName[tagType][foldFieldName(name)]
+	return field, ok
+}
+
+// Get the name a tag format uses for a field, "" if the format has no
+// common way to store it
+func NativeFieldName(field Field, tagType TagType) string {
+	for _, f := range FieldTable {
+		if f.Field == field {
+			return f.Name(tagType)
+		}
+	}
+	return ""
+}
+
+// All canonical fields, in the order of FieldTable
+func Fields() []Field {
+	fields := make([]Field, len(FieldTable))
+	for i, f := range FieldTable {
+		fields[i] = f.Field
+	}
+	return fields
+}
+
+// The tag format of an ID3v2 tag version
+func Id3TagType(version Id3Version) TagType {
+	switch version {
+	case Id3Ver2p2:
+		return TagTypeId3v22
+	case Id3Ver2p3:
+		return TagTypeId3v23
+	}
+	return TagTypeId3v24
+}
+
+// The name of an MP4 atom type, as used by FieldTable
+func Mp4AtomName(typ uint32) string {
+	return string([]byte{byte(typ >> 24), byte(typ >> 16), byte(typ >> 8), byte(typ)})
+}
+
+// The string field of id3 that holds the value of field, nil if the value
+// isn't kept as a string
+func (id3 *Mp3Entry) fieldString(field Field) *string {
+	switch field {
+	case FieldTitle:
+		return &id3.Title
+	case FieldArtist:
+		return &id3.Artist
+	case FieldAlbum:
+		return &id3.Album
+	case FieldAlbumArtist:
+		return &id3.AlbumArtist
+	case FieldComposer:
+		return &id3.Composer
+	case FieldGenre:
+		return &id3.Genre
+	case FieldGrouping:
+		return &id3.Grouping
+	case FieldLyrics:
+		return &id3.Lyrics.Text
+	case FieldDate:
+		return &id3.YearString
+	case FieldArtistSort:
+		return &id3.ArtistSort
+	case FieldAlbumSort:
+		return &id3.AlbumSort
+	case FieldTitleSort:
+		return &id3.TitleSort
+	case FieldAlbumArtistSort:
+		return &id3.AlbumArtistSort
+	case FieldComposerSort:
+		return &id3.ComposerSort
+	case FieldIsrc:
+		return &id3.Isrc
+	case FieldLabel:
+		return &id3.Label
+	case FieldCopyright:
+		return &id3.Copyright
+	case FieldEncodedBy:
+		return &id3.EncodedBy
+	case FieldEncoderSettings:
+		return &id3.EncoderSettings
+	case FieldMood:
+		return &id3.Mood
+	case FieldKey:
+		return &id3.Key
+	case FieldMedia:
+		return &id3.Media
+	case FieldBarcode:
+		return &id3.Barcode
+	case FieldCatalogNumber:
+		return &id3.CatalogNumber
+	case FieldPodcastUrl:
+		return &id3.Podcast.FeedUrl
+	case FieldEpisodeGuid:
+		return &id3.Podcast.EpisodeGuid
+	case FieldDescription:
+		return &id3.Podcast.Description
+	case FieldLongDescription:
+		return &id3.Podcast.LongDescription
+	case FieldCategory:
+		return &id3.Podcast.Category
+	case FieldShow:
+		return &id3.Podcast.Show
+	case FieldNarrator:
+		return &id3.Podcast.Narrator
+	case FieldWork:
+		return &id3.Work
+	case FieldMovementName:
+		return &id3.MovementName
+	case FieldConductor:
+		return &id3.Conductor
+	case FieldEnsemble:
+		return &id3.Ensemble
+	case FieldTrackNumber:
+		return &id3.TrackString
+	case FieldDiscNumber:
+		return &id3.DiscString
+	}
+	return nil
+}
+
+// Set a field of id3 from its value as text. Values that are already set
+// are kept, see AddTagValue. Returns false if id3 can't hold the field.
+func SetField(id3 *Mp3Entry, field Field, value string) bool {
+	switch field {
+	case FieldBpm:
+		// Some taggers write fractions
+		if bpm, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil && bpm > 0 && id3.Bpm == 0 {
+			id3.Bpm = int(bpm + 0.5)
+		}
+		return true
+	case FieldCompilation:
+		id3.Compilation = id3.Compilation || ParseTagBool(value)
+		return true
+	case FieldPodcast:
+		if ParseTagBool(value) {
+			SetPodcast(id3)
+		}
+		return true
+	case FieldMediaKind:
+		if kind, err := strconv.Atoi(strings.TrimSpace(value)); err == nil && id3.Podcast.MediaKind == MediaKindUnknown {
+			id3.Podcast.MediaKind = kind
+		}
+		return true
+	case FieldTrackTotal:
+		if n, _ := ParseTagNumber(value); id3.TrackTotal == 0 {
+			id3.TrackTotal = n
+		}
+		return true
+	case FieldDiscTotal:
+		if n, _ := ParseTagNumber(value); id3.DiscTotal == 0 {
+			id3.DiscTotal = n
+		}
+		return true
+	case FieldComment:
+		AddComment(id3, Comment{Text: value})
+		return true
+	case FieldMovementNumber:
+		// May be given as "n/total"
+		n, total := ParseTagNumber(value)
+		if id3.MovementNumber == 0 {
+			id3.MovementNumber = n
+		}
+		if id3.MovementTotal == 0 {
+			id3.MovementTotal = total
+		}
+		return true
+	case FieldMovementTotal:
+		if n, _ := ParseTagNumber(value); id3.MovementTotal == 0 {
+			id3.MovementTotal = n
+		}
+		return true
+	case FieldPerformer:
+		// "Name (instrument)"
+		name, instrument := value, ""
+		if i := strings.LastIndexByte(value, '('); i > 0 && strings.HasSuffix(value, ")") {
+			name, instrument = value[:i], value[i+1:len(value)-1]
+		}
+		AddCredit(id3, Credit{Role: string(FieldPerformer), Name: name, Instrument: instrument})
+		return true
+	case FieldProducer, FieldEngineer, FieldMixer, FieldArranger, FieldLyricist, FieldRemixer:
+		AddCredit(id3, Credit{Role: string(field), Name: value})
+		return true
+	case FieldKeywords:
+		// A comma separated list
+		for _, keyword := range strings.Split(value, ",") {
+			keyword = strings.TrimSpace(keyword)
+			known := keyword == ""
+			for _, k := range id3.Podcast.Keywords {
+				known = known || k == keyword
+			}
+			if !known {
+				id3.Podcast.Keywords = append(id3.Podcast.Keywords, keyword)
+			}
+		}
+		return true
+	}
+
+	if SetMusicBrainzId(id3, field, value) {
+		return true
+	}
+
+	if p := id3.fieldString(field); p != nil {
+		AddTagValue(id3, p, value)
+		return true
+	}
+
+	return false
+}
+
+// Parse a number of a tag, which may be followed by a total: "3" or "3/12".
+// Returns 0 for the parts that are missing or not a number.
+func ParseTagNumber(value string) (n, total int) {
+	parts := strings.SplitN(value, "/", 2)
+	n, _ = strconv.Atoi(strings.TrimSpace(parts[0]))
+	if len(parts) == 2 {
+		total, _ = strconv.Atoi(strings.TrimSpace(parts[1]))
+	}
+	return n, total
+}
+
+// Add a credit to id3, unless it is already there. Surrounding space is
+// removed, credits without a name are dropped.
+func AddCredit(id3 *Mp3Entry, credit Credit) {
+	credit.Role = strings.TrimSpace(credit.Role)
+	credit.Name = strings.TrimSpace(credit.Name)
+	credit.Instrument = strings.TrimSpace(credit.Instrument)
+	if credit.Name == "" {
+		return
+	}
+
+	for _, c := range id3.Credits {
+		if c == credit {
+			return
+		}
+	}
+	id3.Credits = append(id3.Credits, credit)
+}
+
+// Mark id3 as a podcast episode
+func SetPodcast(id3 *Mp3Entry) {
+	id3.Podcast.IsPodcast = true
+	if id3.Podcast.MediaKind == MediaKindUnknown {
+		id3.Podcast.MediaKind = MediaKindPodcast
+	}
+}
+
+// Parse a flag of a tag, like the compilation flag. It is set by a nonzero
+// number, "true" or "yes".
+func ParseTagBool(value string) bool {
+	value = strings.TrimSpace(value)
+	if n, err := strconv.Atoi(value); err == nil {
+		return n != 0
+	}
+	return strings.EqualFold(value, "true") || strings.EqualFold(value, "yes")
+}
+
+// Get the value of a field of the entry as a string, "" if it isn't set. Of
+// fields with several values, the first one is returned.
+func (id3 *Mp3Entry) GetField(field Field) string {
+	switch field {
+	case FieldDate:
+		if id3.ReleaseDate.Precision != DatePrecisionNone {
+			return id3.ReleaseDate.String()
+		}
+	case FieldOriginalDate:
+		return id3.OriginalDate.String()
+	case FieldRating:
+		if id3.Rating > 0 {
+			return strconv.Itoa(id3.Rating)
+		}
+	case FieldPlayCount:
+		if id3.PlayCount > 0 {
+			return strconv.FormatInt(id3.PlayCount, 10)
+		}
+	case FieldBpm:
+		if id3.Bpm > 0 {
+			return strconv.Itoa(id3.Bpm)
+		}
+	case FieldCompilation:
+		if id3.Compilation {
+			return "1"
+		}
+	case FieldPodcast:
+		if id3.Podcast.IsPodcast {
+			return "1"
+		}
+	case FieldMediaKind:
+		if id3.Podcast.MediaKind != MediaKindUnknown {
+			return strconv.Itoa(id3.Podcast.MediaKind)
+		}
+	case FieldKeywords:
+		return strings.Join(id3.Podcast.Keywords, ", ")
+	case FieldPerformer, FieldProducer, FieldEngineer, FieldMixer, FieldArranger, FieldLyricist, FieldRemixer:
+		for _, credit := range id3.Credits {
+			if credit.Role == string(field) && credit.Instrument != "" {
+				return credit.Name + " (" + credit.Instrument + ")"
+			} else if credit.Role == string(field) {
+				return credit.Name
+			}
+		}
+	case FieldComment:
+		return id3.Comment
+	case FieldTrackTotal:
+		if id3.TrackTotal > 0 {
+			return strconv.Itoa(id3.TrackTotal)
+		}
+	case FieldDiscTotal:
+		if id3.DiscTotal > 0 {
+			return strconv.Itoa(id3.DiscTotal)
+		}
+	case FieldMovementNumber:
+		if id3.MovementNumber > 0 {
+			return strconv.Itoa(id3.MovementNumber)
+		}
+	case FieldMovementTotal:
+		if id3.MovementTotal > 0 {
+			return strconv.Itoa(id3.MovementTotal)
+		}
+	}
+
+	if p := id3.fieldString(field); p != nil {
+		return *p
+	}
+	if p := id3.musicBrainzId(field); p != nil {
+		return *p
+	}
+	if ids := id3.musicBrainzIds(field); ids != nil && len(*ids) > 0 {
+		return (*ids)[0]
+	}
+	return ""
+}
@@ -19,6 +19,7 @@ func GetFlacMetadata(fd *tools.File, id3 *Mp3Entry) (err error) {
 	}
 
 	var buf [260]byte
+	var vorbisPos, vorbisLen int64
 	lastMetadata := 0
 	rc := false
 
@@ -85,9 +86,15 @@ func GetFlacMetadata(fd *tools.File, id3 *Mp3Entry) (err error) {
 				return errors.New("flac length invalid")
 			}
 		} else if blockType == 4 {
-			// 4 is the VORBIS_COMMENT block
-			if _, err := ReadVorbisTags(fd, id3, i); err != nil {
-				return err
+			// 4 is the VORBIS_COMMENT block, read along with an ID3v2 tag
+			// in front of the stream below
+			if vorbisPos, err = fd.Seek(0, io.SeekCurrent); err != nil {
+				return errors.Wrap(err, 0)
+			}
+			vorbisLen = i
+
+			if _, err = fd.Seek(i, io.SeekCurrent); err != nil {
+				return errors.Wrap(err, 0)
 			}
 		} else if blockType == 6 {
 			// 6 is the PICTURE block
@@ -147,5 +154,19 @@ func GetFlacMetadata(fd *tools.File, id3 *Mp3Entry) (err error) {
 		}
 	}
 
-	return nil
+	return ReadTagBlocks(id3, []TagBlockReader{
+		{TagBlockId3v2, func() error {
+			return ReadLeadingId3v2Tag(fd, id3)
+		}},
+		{TagBlockNative, func() error {
+			if vorbisLen == 0 {
+				return nil
+			}
+			if _, err := fd.Seek(vorbisPos, io.SeekStart); err != nil {
+				return errors.Wrap(err, 0)
+			}
+			_, err := ReadVorbisTags(fd, id3, vorbisLen)
+			return err
+		}},
+	})
 }
@@ -0,0 +1,676 @@
+package metadata
+
+import (
+	"bytes"
+	"compress/zlib"
+	"github.com/go-errors/errors"
+	"io"
+	"io/ioutil"
+	"rbmetadata-go/firmware/common"
+	"rbmetadata-go/tools"
+	"sort"
+	"unicode/utf16"
+)
+
+// A single frame of an ID3v2 tag
+type Id3Frame struct {
+	// Frame id as found in the tag, three characters for ID3v2.2
+	Id string
+	// Frame id converted to its ID3v2.4 equivalent. Frames that were
+	// dropped in ID3v2.4 without a replacement keep their (ID3v2.3) id.
+	NormalizedId string
+	// Version of the tag holding the frame
+	Version Id3Version
+	// Frame flags, 0 for ID3v2.2
+	Flags uint16
+	// Frame payload, with the tag's unsynchronisation and the frame format
+	// flags undone: it doesn't contain the grouping identity, encryption
+	// method or data length indicator, and compressed frames are inflated.
+	Data []byte
+	// Byte offset of Data in the file, -1 if Data doesn't appear in the file
+	// as is because unsynchronisation or compression had to be undone
+	DataOffset int64
+	// Group identity of frames with the grouping flag
+	GroupId byte
+	// Data is encrypted, and can't be decoded
+	Encrypted bool
+	// Encoding declared by text and URL frames, 0 for other frames
+	Encoding CharacterEncoding
+	// Decoded strings of text and URL frames. For TXXX and WXXX the first
+	// value is the description.
+	Values []string
+	// Byte offset of the frame header in the file, -1 for sub-frames of
+	// chapter frames that don't appear in the file as is
+	Offset int64
+	// Frames embedded in CHAP and CTOC frames
+	SubFrames []Id3Frame
+}
+
+// A complete ID3v2 tag
+type Id3v2Tag struct {
+	Version Id3Version
+	Flags   byte
+	// Byte offset of the tag header in the file
+	Offset int64
+	// Total length, including header and footer
+	Length int64
+	Frames []Id3Frame
+}
+
+// Frame ids of ID3v2.2 and their ID3v2.3 equivalents
+var Id3v22FrameIds = map[string]string{
+	"BUF": "RBUF", "CNT": "PCNT", "COM": "COMM", "CRA": "AENC",
+	"ETC": "ETCO", "EQU": "EQUA", "GEO": "GEOB", "IPL": "IPLS",
+	"LNK": "LINK", "MCI": "MCDI", "MLL": "MLLT", "PIC": "APIC",
+	"POP": "POPM", "REV": "RVRB", "RVA": "RVAD", "SLT": "SYLT",
+	"STC": "SYTC", "TAL": "TALB", "TBP": "TBPM", "TCM": "TCOM",
+	"TCO": "TCON", "TCR": "TCOP", "TDA": "TDAT", "TDY": "TDLY",
+	"TEN": "TENC", "TFT": "TFLT", "TIM": "TIME", "TKE": "TKEY",
+	"TLA": "TLAN", "TLE": "TLEN", "TMT": "TMED", "TOA": "TOPE",
+	"TOF": "TOFN", "TOL": "TOLY", "TOR": "TORY", "TOT": "TOAL",
+	"TP1": "TPE1", "TP2": "TPE2", "TP3": "TPE3", "TP4": "TPE4",
+	"TPA": "TPOS", "TPB": "TPUB", "TRC": "TSRC", "TRD": "TRDA",
+	"TRK": "TRCK", "TSI": "TSIZ", "TSS": "TSSE", "TT1": "TIT1",
+	"TT2": "TIT2", "TT3": "TIT3", "TXT": "TEXT", "TXX": "TXXX",
+	"TYE": "TYER", "UFI": "UFID", "ULT": "USLT", "WAF": "WOAF",
+	"WAR": "WOAR", "WAS": "WOAS", "WCM": "WCOM", "WCP": "WCOP",
+	"WPB": "WPUB", "WXX": "WXXX",
+	// iTunes extensions
+	"TCP": "TCMP", "TST": "TSOT", "TSP": "TSOP", "TSA": "TSOA",
+	"TS2": "TSO2", "TSC": "TSOC", "PCS": "PCST", "TDS": "TDES",
+	"TID": "TGID", "TCT": "TCAT", "TKW": "TKWD", "WFD": "WFED",
+	"MVN": "MVNM", "MVI": "MVIN", "GP1": "GRP1",
+}
+
+// Frame ids of ID3v2.3 that were replaced in ID3v2.4
+var Id3v23FrameIds = map[string]string{
+	"TYER": "TDRC",
+	"TORY": "TDOR",
+	"IPLS": "TIPL",
+	"RVAD": "RVA2",
+	"EQUA": "EQU2",
+	// Unofficial sort order frames
+	"XSOA": "TSOA",
+	"XSOP": "TSOP",
+	"XSOT": "TSOT",
+}
+
+// Get the ID3v2.4 id of a frame of the given tag version
+func NormalizeId3FrameId(id string, version Id3Version) string {
+	if version == Id3Ver2p2 {
+		if v3, ok := Id3v22FrameIds[id]; ok {
+			id = v3
+		}
+	}
+
+	if version <= Id3Ver2p3 {
+		if v4, ok := Id3v23FrameIds[id]; ok {
+			id = v4
+		}
+	}
+
+	return id
+}
+
+// Open filename and return all ID3v2 frames of all its ID3v2 tags.
+func Id3v2Frames(filename string) (frames []Id3Frame, err error) {
+	f, err := tools.Open(filename, true, -1)
+	if err != nil {
+		return nil, errors.Wrap(err, 0)
+	}
+
+	defer func() {
+		if err == nil {
+			err = f.Close()
+		} else {
+			_ = f.Close()
+		}
+	}()
+
+	return GetId3v2Frames(f)
+}
+
+// Get the frames of all ID3v2 tags of a file, in the order they appear in
+// the file: the prepended tag, tags chained to it by SEEK frames and the
+// appended tag.
+func GetId3v2Frames(fd *tools.File) ([]Id3Frame, error) {
+	tags, err := GetId3v2Tags(fd)
+	if err != nil {
+		return nil, err
+	}
+
+	var frames []Id3Frame
+	for _, tag := range tags {
+		frames = append(frames, tag.Frames...)
+	}
+
+	return frames, nil
+}
+
+// Get all ID3v2 tags of a file, see GetId3v2Frames.
+func GetId3v2Tags(fd *tools.File) ([]Id3v2Tag, error) {
+	var tags []Id3v2Tag
+
+	var id3 Mp3Entry
+	id3.Filesize = fd.FileSize()
+	if n, err := GetId3v1Len(fd); err != nil {
+		return nil, err
+	} else {
+		id3.Id3v1len = uint64(n)
+	}
+	if err := ReadLyrics3Tag(fd, &id3); err != nil {
+		return nil, err
+	}
+
+	err := WalkId3v2Tags(fd, &id3, func(pos, length int64) (int64, error) {
+		tag, err := ReadId3v2Frames(fd, pos)
+		if err != nil {
+			return 0, err
+		}
+		tags = append(tags, *tag)
+
+		seek := int64(0)
+		for _, frame := range tag.Frames {
+			if frame.NormalizedId == "SEEK" && len(frame.Data) >= 4 {
+				seek = int64(GetLongBE(frame.Data))
+			}
+		}
+		return seek, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+// Read all frames of the ID3v2 tag starting at pos.
+func ReadId3v2Frames(fd *tools.File, pos int64) (*Id3v2Tag, error) {
+	length, err := GetId3v2TagLen(fd, pos)
+	if err != nil {
+		return nil, err
+	} else if length == 0 {
+		return nil, errors.Errorf("no id3v2 tag at offset %d", pos)
+	}
+
+	buf := make([]byte, length)
+	if rd, err := fd.Read(buf); err != nil && err != io.EOF {
+		return nil, errors.Wrap(err, 0)
+	} else if rd < 10 {
+		return nil, errors.New("failed to read id3v2 tag")
+	} else {
+		buf = buf[:rd]
+	}
+
+	tag := &Id3v2Tag{
+		Flags:  buf[5],
+		Offset: pos,
+		Length: length,
+	}
+
+	switch buf[3] {
+	case 2:
+		tag.Version = Id3Ver2p2
+	case 3:
+		tag.Version = Id3Ver2p3
+	case 4:
+		tag.Version = Id3Ver2p4
+	default:
+		return nil, errors.Errorf("unsupported id3 version 2.%d", buf[3])
+	}
+
+	body := buf[10:]
+	if tag.Flags&0x10 != 0 && len(body) >= 10 {
+		// Footer
+		body = body[:len(body)-10]
+	}
+
+	if tag.Version == Id3Ver2p2 && tag.Flags&0x40 != 0 {
+		// ID3v2.2 compression was never defined, so the tag can't be read
+		return tag, nil
+	}
+
+	// Up to ID3v2.3 the unsynchronisation is applied to the whole tag,
+	// ID3v2.4 applies it per frame.
+	var removed []int
+	if tag.Flags&0x80 != 0 && tag.Version <= Id3Ver2p3 {
+		body, removed = unsyncId3Data(body)
+	}
+
+	// File offset of a position in body
+	fileOffset := func(p int) int64 {
+		return pos + 10 + int64(p) + int64(sort.SearchInts(removed, p+1))
+	}
+
+	p := 0
+
+	// Skip the extended header
+	if tag.Flags&0x40 != 0 && len(body) >= 4 {
+		if tag.Version == Id3Ver2p3 {
+			// The size doesn't include the size field itself
+			p = int(GetLongBE(body)) + 4
+		} else {
+			p = int(Unsync(body[0], body[1], body[2], body[3]))
+		}
+
+		if p < 0 || p > len(body) {
+			return tag, errors.Errorf("id3v2 extended header of %d bytes doesn't fit the tag of %d bytes", p, len(body))
+		}
+	}
+
+	tag.Frames = ParseId3Frames(body[p:], tag.Version, tag.Flags&0x80 != 0, func(q int) int64 {
+		return fileOffset(p + q)
+	})
+
+	return tag, nil
+}
+
+// Parse the frames in data, which holds the frames of a tag of the given
+// version or the sub-frames of a CHAP or CTOC frame. fileOffset maps a
+// position in data to its offset in the file, or returns -1 if data doesn't
+// appear in the file as is. tagUnsync tells whether the tag header has the
+// unsynchronisation flag set.
+func ParseId3Frames(data []byte, version Id3Version, tagUnsync bool, fileOffset func(p int) int64) []Id3Frame {
+	var frames []Id3Frame
+
+	idLen, headerLen := 4, 10
+	if version == Id3Ver2p2 {
+		idLen, headerLen = 3, 6
+	}
+
+	p := 0
+	for p+headerLen <= len(data) {
+		header := data[p : p+headerLen]
+
+		if !isId3FrameId(header[:idLen]) {
+			// Padding, or garbage
+			break
+		}
+
+		var size int
+		var flags uint16
+		switch version {
+		case Id3Ver2p2:
+			size = int(Bytes2Int(0, header[3], header[4], header[5]))
+		case Id3Ver2p3:
+			size = int(Bytes2Int(header[4], header[5], header[6], header[7]))
+			flags = uint16(header[8])<<8 | uint16(header[9])
+		default:
+			if (header[4]|header[5]|header[6]|header[7])&0x80 != 0 {
+				// Some taggers don't write synchsafe sizes
+				size = int(Bytes2Int(header[4], header[5], header[6], header[7]))
+			} else {
+				size = int(Unsync(header[4], header[5], header[6], header[7]))
+			}
+			flags = uint16(header[8])<<8 | uint16(header[9])
+		}
+
+		if size < 0 || p+headerLen+size > len(data) {
+			break
+		}
+
+		start, end := p+headerLen, p+headerLen+size
+		frame := Id3Frame{
+			Id:         string(header[:idLen]),
+			Version:    version,
+			Flags:      flags,
+			Data:       data[start:end],
+			Offset:     fileOffset(p),
+			DataOffset: fileOffset(start),
+		}
+		p = end
+
+		// Bytes dropped by the tag's unsynchronisation
+		if frame.DataOffset < 0 || fileOffset(end)-frame.DataOffset != int64(size) {
+			frame.DataOffset = -1
+		}
+
+		if err := DecodeId3FrameFlags(&frame, tagUnsync); err != nil {
+			// Skip frames that can't be decoded
+			continue
+		}
+
+		frame.NormalizedId = NormalizeId3FrameId(frame.Id, version)
+		if frame.Encrypted {
+			frames = append(frames, frame)
+			continue
+		}
+
+		frame.Encoding, frame.Values = DecodeId3FrameText(frame.NormalizedId, frame.Data)
+
+		// Chapter frames embed frames of their own. Their unsynchronisation
+		// was undone together with the one of the chapter frame.
+		if n := id3SubFramesStart(&frame); n >= 0 {
+			base := frame.DataOffset
+			frame.SubFrames = ParseId3Frames(frame.Data[n:], version, false, func(q int) int64 {
+				if base < 0 {
+					return -1
+				}
+				return base + int64(n+q)
+			})
+		}
+
+		frames = append(frames, frame)
+	}
+
+	return frames
+}
+
+// Get the position of the sub-frames in the data of a CHAP or CTOC frame, -1
+// for other frames and malformed chapter frames.
+func id3SubFramesStart(frame *Id3Frame) int {
+	data := frame.Data
+
+	switch frame.NormalizedId {
+	case "CHAP":
+		// Element id, start and end time, start and end offset
+		id, rest := NextId3String(0, data)
+		if len(rest) < 16 || len(id) == len(data) {
+			return -1
+		}
+		return len(data) - len(rest) + 16
+	case "CTOC":
+		// Element id, flags, entry count and the child element ids
+		_, rest := NextId3String(0, data)
+		if len(rest) < 2 {
+			return -1
+		}
+		count := int(rest[1])
+		rest = rest[2:]
+		for i := 0; i < count; i++ {
+			if len(rest) == 0 {
+				return -1
+			}
+			_, rest = NextId3String(0, rest)
+		}
+		return len(data) - len(rest)
+	}
+
+	return -1
+}
+
+// Undo the frame format flags of frame: strip the grouping identity, the
+// encryption method and the data length indicator, undo the frame's
+// unsynchronisation and inflate compressed frames. The payload of encrypted
+// frames is kept as is, with frame.Encrypted set. tagUnsync tells whether the
+// tag header has the unsynchronisation flag set, which for ID3v2.4 applies
+// to all frames.
+func DecodeId3FrameFlags(frame *Id3Frame, tagUnsync bool) error {
+	var compressed, encrypted, grouped, unsync bool
+	dataLen := -1
+	data := frame.Data
+
+	// Additional data in front of the payload, in the order it is stored
+	next := func(n int) ([]byte, error) {
+		if len(data) < n {
+			return nil, errors.Errorf("%s frame too short for its flags", frame.Id)
+		}
+		b := data[:n]
+		data = data[n:]
+		return b, nil
+	}
+
+	switch frame.Version {
+	case Id3Ver2p3:
+		compressed = frame.Flags&Id3v23FrameCompression != 0
+		encrypted = frame.Flags&Id3v23FrameEncryption != 0
+		grouped = frame.Flags&Id3v23FrameGrouping != 0
+
+		if compressed {
+			b, err := next(4)
+			if err != nil {
+				return err
+			}
+			dataLen = int(GetLongBE(b))
+		}
+		if encrypted {
+			if _, err := next(1); err != nil {
+				return err
+			}
+		}
+		if grouped {
+			b, err := next(1)
+			if err != nil {
+				return err
+			}
+			frame.GroupId = b[0]
+		}
+	case Id3Ver2p4:
+		grouped = frame.Flags&Id3v24FrameGrouping != 0
+		compressed = frame.Flags&Id3v24FrameCompression != 0
+		encrypted = frame.Flags&Id3v24FrameEncryption != 0
+		unsync = frame.Flags&Id3v24FrameUnsync != 0 || tagUnsync
+
+		if grouped {
+			b, err := next(1)
+			if err != nil {
+				return err
+			}
+			frame.GroupId = b[0]
+		}
+		if encrypted {
+			if _, err := next(1); err != nil {
+				return err
+			}
+		}
+		if frame.Flags&Id3v24FrameDataLength != 0 {
+			b, err := next(4)
+			if err != nil {
+				return err
+			}
+			dataLen = int(Unsync(b[0], b[1], b[2], b[3]))
+		}
+	default:
+		return nil
+	}
+
+	if frame.DataOffset >= 0 {
+		frame.DataOffset += int64(len(frame.Data) - len(data))
+	}
+
+	if unsync {
+		var removed []int
+		if data, removed = unsyncId3Data(data); len(removed) > 0 {
+			frame.DataOffset = -1
+		}
+	}
+
+	frame.Data = data
+
+	if encrypted {
+		// There is no way to know the encryption method
+		frame.Encrypted = true
+		return nil
+	}
+
+	if compressed {
+		r, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return errors.Wrap(err, 0)
+		}
+		defer func() {
+			_ = r.Close()
+		}()
+
+		limit := int64(Id3V2MaxDecompressedSize)
+		if dataLen >= 0 && int64(dataLen) < limit {
+			limit = int64(dataLen)
+		}
+
+		if frame.Data, err = ioutil.ReadAll(io.LimitReader(r, limit)); err != nil {
+			return errors.Wrap(err, 0)
+		}
+		frame.DataOffset = -1
+	}
+
+	return nil
+}
+
+// Decode the strings of text (T***) and URL (W***) frames. Returns 0, nil
+// for other frames.
+func DecodeId3FrameText(id string, data []byte) (CharacterEncoding, []string) {
+	if len(id) != 4 || len(data) == 0 {
+		return 0, nil
+	}
+
+	switch {
+	case id == "WXXX":
+		// Encoding, description and an ISO-8859-1 URL
+		strs := SplitId3Strings(data[0], data[1:])
+		if len(strs) == 0 {
+			return 0, nil
+		}
+		enc, desc := DecodeId3String(data[0], strs[0])
+		url := data[1+len(strs[0]):]
+		url = url[id3TerminatorLen(data[0], url):]
+		return enc, []string{desc, common.IsoDecode(trimId3Nul(url), -1)}
+	case id[0] == 'T', id == "GRP1", id == "MVNM", id == "MVIN", id == "WFED":
+		// Including iTunes' text frames, WFED despite its name
+		var enc CharacterEncoding
+		var values []string
+		for i, str := range SplitId3Strings(data[0], data[1:]) {
+			e, value := DecodeId3String(data[0], str)
+			if i == 0 {
+				enc = e
+			}
+			values = append(values, value)
+		}
+		return enc, values
+	case id[0] == 'W':
+		return CharEncIso88591, []string{common.IsoDecode(trimId3Nul(data), -1)}
+	}
+
+	return 0, nil
+}
+
+// Split NUL separated ID3 strings of the given encoding. A trailing
+// terminator doesn't start another string.
+func SplitId3Strings(encoding byte, data []byte) [][]byte {
+	var strs [][]byte
+
+	for len(data) > 0 {
+		var str []byte
+		str, data = NextId3String(encoding, data)
+		strs = append(strs, str)
+	}
+
+	return strs
+}
+
+// Split off the first NUL terminated ID3 string of the given encoding.
+// Returns the string, without its terminator, and the data following it.
+func NextId3String(encoding byte, data []byte) ([]byte, []byte) {
+	end := len(data)
+	if encoding == 0x01 || encoding == 0x02 {
+		for i := 0; i+1 < len(data); i += 2 {
+			if data[i] == 0 && data[i+1] == 0 {
+				end = i
+				break
+			}
+		}
+	} else {
+		for i := 0; i < len(data); i++ {
+			if data[i] == 0 {
+				end = i
+				break
+			}
+		}
+	}
+
+	rest := data[end:]
+	return data[:end], rest[id3TerminatorLen(encoding, rest):]
+}
+
+// Decode a single ID3 string of the given encoding byte to UTF-8. Returns
+// the actual encoding, which for UTF-16 with BOM depends on the BOM.
+func DecodeId3String(encoding byte, data []byte) (CharacterEncoding, string) {
+	switch encoding {
+	case 0x01, 0x02:
+		le := false
+		enc := CharEncUtf16Be
+
+		if len(data) >= 2 {
+			if data[0] == 0xFF && data[1] == 0xFE {
+				// Little endian BOM
+				le = true
+				data = data[2:]
+			} else if data[0] == 0xFE && data[1] == 0xFF {
+				// Big endian BOM
+				data = data[2:]
+			} else if encoding == 0x01 && data[1] == 0 {
+				// If there is no BOM (which is a specification
+				// violation), let's try to guess it. If one of the bytes
+				// is 0x00, it is probably the most significant one.
+				le = true
+			}
+		}
+
+		units := make([]uint16, len(data)/2)
+		for i := range units {
+			if le {
+				units[i] = common.GetLe16(data[2*i:])
+			} else {
+				units[i] = common.GetBe16(data[2*i:])
+			}
+		}
+
+		if le {
+			enc = CharEncUtf16Le
+		}
+		return enc, string(utf16.Decode(units))
+	case 0x03:
+		return CharEncUtf8, string(data)
+	default:
+		return CharEncIso88591, common.IsoDecode(data, -1)
+	}
+}
+
+// Length of the string terminator at the start of data
+func id3TerminatorLen(encoding byte, data []byte) int {
+	n := 1
+	if encoding == 0x01 || encoding == 0x02 {
+		n = 2
+	}
+	if n > len(data) {
+		n = len(data)
+	}
+	return n
+}
+
+func trimId3Nul(data []byte) []byte {
+	for len(data) > 0 && data[len(data)-1] == 0 {
+		data = data[:len(data)-1]
+	}
+	return data
+}
+
+// Frame ids only consist of upper case letters and digits
+func isId3FrameId(id []byte) bool {
+	for _, c := range id {
+		if !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// Undo unsynchronisation, i.e. drop every 0x00 following a 0xFF. Returns
+// the data and the positions in it where a byte was dropped.
+func unsyncId3Data(data []byte) ([]byte, []int) {
+	var removed []int
+	out := make([]byte, len(data))
+
+	// Unsynchronize one byte at a time, to see which ones it drops
+	ffFound := false
+	n := 0
+	for _, c := range data {
+		out[n] = c
+		if Unsynchronize(out[n:], 1, &ffFound) == 0 {
+			removed = append(removed, n)
+		} else {
+			n++
+		}
+	}
+
+	return out[:n], removed
+}
@@ -0,0 +1,121 @@
+package metadata
+
+import (
+	"bytes"
+	"compress/zlib"
+	"reflect"
+	"testing"
+)
+
+func TestUnsyncId3Data(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        []byte
+		want        []byte
+		wantRemoved []int
+	}{
+		{"empty", nil, []byte{}, nil},
+		{"nothing to drop", []byte{0x01, 0xFF, 0x02}, []byte{0x01, 0xFF, 0x02}, nil},
+		{"ff 00", []byte{0xFF, 0x00, 0xE0}, []byte{0xFF, 0xE0}, []int{1}},
+		{"several", []byte{0xFF, 0x00, 0x01, 0xFF, 0x00, 0xFF, 0x00}, []byte{0xFF, 0x01, 0xFF, 0xFF}, []int{1, 3, 4}},
+		{"ff ff 00", []byte{0xFF, 0xFF, 0x00}, []byte{0xFF, 0xFF, 0x00}, nil},
+		{"ff 00 00", []byte{0xFF, 0x00, 0x00}, []byte{0xFF, 0x00}, []int{1}},
+		{"trailing ff", []byte{0x01, 0xFF}, []byte{0x01, 0xFF}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := append([]byte(nil), tt.data...)
+			got, removed := unsyncId3Data(data)
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("unsyncId3Data() = % x, want % x", got, tt.want)
+			}
+			if !reflect.DeepEqual(removed, tt.wantRemoved) {
+				t.Errorf("removed = %v, want %v", removed, tt.wantRemoved)
+			}
+			if !bytes.Equal(data, tt.data) {
+				t.Errorf("input changed to % x", data)
+			}
+		})
+	}
+}
+
+func zlibData(data string) []byte {
+	var b bytes.Buffer
+	w := zlib.NewWriter(&b)
+	_, _ = w.Write([]byte(data))
+	_ = w.Close()
+	return b.Bytes()
+}
+
+func TestDecodeId3FrameFlags(t *testing.T) {
+	cat := func(parts ...[]byte) []byte {
+		return bytes.Join(parts, nil)
+	}
+	compressed := zlibData("\x00Compressed")
+
+	tests := []struct {
+		name          string
+		version       Id3Version
+		flags         uint16
+		tagUnsync     bool
+		data          []byte
+		want          []byte
+		wantOffset    int64
+		wantGroup     byte
+		wantEncrypted bool
+		wantErr       bool
+	}{
+		{"v2.2", Id3Ver2p2, 0, false, []byte("\x00Text"), []byte("\x00Text"), 100, 0, false, false},
+		{"v2.3 no flags", Id3Ver2p3, 0, false, []byte("\x00Text"), []byte("\x00Text"), 100, 0, false, false},
+		{"v2.3 grouping", Id3Ver2p3, Id3v23FrameGrouping, false,
+			[]byte("\x07\x00Text"), []byte("\x00Text"), 101, 7, false, false},
+		{"v2.3 compression", Id3Ver2p3, Id3v23FrameCompression, false,
+			cat([]byte{0, 0, 0, 11}, compressed), []byte("\x00Compressed"), -1, 0, false, false},
+		{"v2.3 compression, encryption and grouping", Id3Ver2p3,
+			Id3v23FrameCompression | Id3v23FrameEncryption | Id3v23FrameGrouping, false,
+			cat([]byte{0, 0, 0, 11, 0x80, 0x05}, compressed), compressed, 106, 5, true, false},
+		{"v2.3 compression without size", Id3Ver2p3, Id3v23FrameCompression, false,
+			[]byte{0, 0}, nil, 0, 0, false, true},
+		{"v2.4 data length and unsync", Id3Ver2p4, Id3v24FrameDataLength | Id3v24FrameUnsync, false,
+			[]byte{0, 0, 0, 3, 0x00, 0xFF, 0x00, 0xE0}, []byte{0x00, 0xFF, 0xE0}, -1, 0, false, false},
+		{"v2.4 tag unsync with nothing to undo", Id3Ver2p4, 0, true,
+			[]byte("\x00Text"), []byte("\x00Text"), 100, 0, false, false},
+		{"v2.4 grouping, data length and compression", Id3Ver2p4,
+			Id3v24FrameGrouping | Id3v24FrameDataLength | Id3v24FrameCompression, false,
+			cat([]byte{0x09, 0, 0, 0, 11}, compressed), []byte("\x00Compressed"), -1, 9, false, false},
+		{"v2.4 data length limits the inflated size", Id3Ver2p4,
+			Id3v24FrameDataLength | Id3v24FrameCompression, false,
+			cat([]byte{0, 0, 0, 4}, compressed), []byte("\x00Com"), -1, 0, false, false},
+		{"v2.4 grouping without group", Id3Ver2p4, Id3v24FrameGrouping, false,
+			nil, nil, 0, 0, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame := Id3Frame{Id: "TIT2", Version: tt.version, Flags: tt.flags, Data: tt.data, DataOffset: 100}
+			err := DecodeId3FrameFlags(&frame, tt.tagUnsync)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("DecodeId3FrameFlags() succeeded")
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(frame.Data, tt.want) {
+				t.Errorf("Data = %q, want %q", frame.Data, tt.want)
+			}
+			if frame.DataOffset != tt.wantOffset {
+				t.Errorf("DataOffset = %d, want %d", frame.DataOffset, tt.wantOffset)
+			}
+			if frame.GroupId != tt.wantGroup {
+				t.Errorf("GroupId = %d, want %d", frame.GroupId, tt.wantGroup)
+			}
+			if frame.Encrypted != tt.wantEncrypted {
+				t.Errorf("Encrypted = %v, want %v", frame.Encrypted, tt.wantEncrypted)
+			}
+		})
+	}
+}
@@ -1,14 +1,16 @@
 package metadata
 
 import (
+	"bytes"
 	"fmt"
 	"github.com/go-errors/errors"
 	"io"
 	"math"
-	"rbmetadata-go/apps"
 	"rbmetadata-go/firmware/common"
 	"rbmetadata-go/tools"
+	"sort"
 	"strconv"
+	"strings"
 	"unicode"
 )
 
@@ -40,14 +42,24 @@ var Genres = []string{
 	"BritPop", "Negerpunk", "Polsk Punk", "Beat", "Christian Gangsta Rap",
 	"Heavy Metal", "Black Metal", "Crossover", "Contemporary Christian",
 	"Christian Rock", "Merengue", "Salsa", "Thrash Metal", "Anime", "Jpop",
-	"Synthpop",
+	"Synthpop", "Abstract", "Art Rock", "Baroque", "Bhangra", "Big Beat",
+	"Breakbeat", "Chillout", "Downtempo", "Dub", "EBM", "Eclectic", "Electro",
+	"Electroclash", "Emo", "Experimental", "Garage", "Global", "IDM",
+	"Illbient", "Industro-Goth", "Jam Band", "Krautrock", "Leftfield",
+	"Lounge", "Math Rock", "New Romantic", "Nu-Breakz", "Post-Punk",
+	"Post-Rock", "Psytrance", "Shoegaze", "Space Rock", "Trop Rock",
+	"World Music", "Neoclassical", "Audiobook", "Audio Theatre",
+	"Neue Deutsche Welle", "Podcast", "Indie Rock", "G-Funk", "Dubstep",
+	"Garage Rock", "Psybient",
 }
 
 // Structure for ID3 Tag extraction information
 type TagResolver struct {
 	Tag    string
 	Offset func(id3 *Mp3Entry) *string
-	PPFunc func(id3 *Mp3Entry, tag []byte, bufferPos int) (int, error)
+	// Post-processes the frame data. pos is a position in the tag buffer of
+	// the C code, which has no counterpart here: it is returned unchanged.
+	PPFunc func(id3 *Mp3Entry, tag []byte, pos int) (int, error)
 	Binary bool
 }
 
@@ -189,50 +201,12 @@ var TagList = []TagResolver{
 		Binary: false,
 	},
 	{
-		Tag: "TIT1",
-		Offset: func(id3 *Mp3Entry) *string {
-			return &id3.Grouping
-		},
-		PPFunc: nil,
-		Binary: false,
-	},
-	{
-		Tag: "TT1",
-		Offset: func(id3 *Mp3Entry) *string {
-			return &id3.Grouping
-		},
-		PPFunc: nil,
-		Binary: false,
-	},
-	{
-		Tag: "COMM",
-		Offset: func(id3 *Mp3Entry) *string {
-			return &id3.Comment
-		},
-		PPFunc: nil,
-		Binary: false,
-	},
-	{
-		Tag: "COM",
-		Offset: func(id3 *Mp3Entry) *string {
-			return &id3.Comment
-		},
-		PPFunc: nil,
-		Binary: false,
-	},
-	{
-		Tag: "TCON",
-		Offset: func(id3 *Mp3Entry) *string {
-			return &id3.Genre
-		},
+		Tag:    "TCON",
 		PPFunc: ParseGenre,
 		Binary: false,
 	},
 	{
-		Tag: "TCO",
-		Offset: func(id3 *Mp3Entry) *string {
-			return &id3.Genre
-		},
+		Tag:    "TCO",
 		PPFunc: ParseGenre,
 		Binary: false,
 	},
@@ -264,119 +238,362 @@ var TagList = []TagResolver{
 		Tag:    "UFID",
 		Offset: nil,
 		PPFunc: ParseMbtid,
+		Binary: true,
+	},
+	{
+		Tag:    "USLT",
+		Offset: nil,
+		PPFunc: ParseLyrics,
+		Binary: true,
+	},
+	{
+		Tag:    "ULT",
+		Offset: nil,
+		PPFunc: ParseLyrics,
+		Binary: true,
+	},
+	{
+		Tag:    "SYLT",
+		Offset: nil,
+		PPFunc: ParseSyncedLyrics,
+		Binary: true,
+	},
+	{
+		Tag:    "SLT",
+		Offset: nil,
+		PPFunc: ParseSyncedLyrics,
+		Binary: true,
+	},
+	{
+		Tag:    "POPM",
+		Offset: nil,
+		PPFunc: ParsePopm,
+		Binary: true,
+	},
+	{
+		Tag:    "POP",
+		Offset: nil,
+		PPFunc: ParsePopm,
+		Binary: true,
+	},
+	{
+		Tag:    "PCNT",
+		Offset: nil,
+		PPFunc: ParsePlayCount,
+		Binary: true,
+	},
+	{
+		Tag:    "CNT",
+		Offset: nil,
+		PPFunc: ParsePlayCount,
+		Binary: true,
+	},
+	{
+		Tag:    "TIPL",
+		Offset: nil,
+		PPFunc: ParseCredits,
+		Binary: false,
+	},
+	{
+		Tag:    "IPLS",
+		Offset: nil,
+		PPFunc: ParseCredits,
+		Binary: false,
+	},
+	{
+		Tag:    "IPL",
+		Offset: nil,
+		PPFunc: ParseCredits,
+		Binary: false,
+	},
+	{
+		Tag:    "TMCL",
+		Offset: nil,
+		PPFunc: ParseMusicianCredits,
 		Binary: false,
 	},
+	{
+		Tag:    "PCST",
+		Offset: nil,
+		PPFunc: ParsePodcast,
+		Binary: true,
+	},
+	{
+		Tag:    "PCS",
+		Offset: nil,
+		PPFunc: ParsePodcast,
+		Binary: true,
+	},
+	{
+		Tag:    "SEEK",
+		Offset: nil,
+		PPFunc: ParseSeek,
+		Binary: true,
+	},
 }
 
 func ParseRva2(id3 *Mp3Entry, tag []byte, pos int) (int, error) {
 	desc := tools.CString(tag)
 	descLen := len(desc)
 
-	startPos := Id3V2BufSize - len(tag)
-	endPos := startPos + descLen + 5
-
-	value := tag[descLen+1:]
-
 	// Only parse RVA2 replaygain tags if tag version == 2.4 and channel
 	// type is master volume.
-	if id3.Id3Version == Id3Ver2p4 && endPos < pos && value[0] == 1 {
-		value = value[1:]
+	if id3.Id3Version != Id3Ver2p4 || len(tag) < descLen+5 || tag[descLen+1] != 1 {
+		return pos, nil
+	}
 
-		// The RVA2 specification is unclear on some things (id string and
-		// peak volume), but this matches how Quod Libet use them.
+	value := tag[descLen+2:]
 
-		album := false
-		peak := 0
-		gain := int16(value[0])<<8 | int16(value[1])
-		value = value[2:]
+	// The RVA2 specification is unclear on some things (id string and
+	// peak volume), but this matches how Quod Libet use them.
 
-		peakBits := value[0]
-		value = value[1:]
+	album := false
+	peak := 0
+	gain := int16(value[0])<<8 | int16(value[1])
+	value = value[2:]
 
-		peakBytes := int32((peakBits + 7) / 8)
+	peakBits := value[0]
+	value = value[1:]
 
-		// Only use the topmost 24 bits for peak volume
-		if peakBytes > 3 {
-			peakBytes = 3
-		}
+	peakBytes := int32((peakBits + 7) / 8)
 
-		// Make sure the peak bits were read
-		if endPos+int(peakBytes) < pos {
-			shift := ((8 - (int32(peakBits) & 7)) & 7) + (3-peakBytes)*8
+	// Only use the topmost 24 bits for peak volume
+	if peakBytes > 3 {
+		peakBytes = 3
+	}
 
-			for ; peakBytes != 0; peakBytes-- {
-				peak <<= 8
-				peak += int(value[0])
-				value = value[1:]
-			}
+	// Make sure the peak bits are there
+	if int(peakBytes) <= len(value) {
+		shift := ((8 - (int32(peakBits) & 7)) & 7) + (3-peakBytes)*8
 
-			peak <<= shift
+		for ; peakBytes != 0; peakBytes-- {
+			peak <<= 8
+			peak += int(value[0])
+			value = value[1:]
+		}
 
-			if peakBytes > 24 {
-				peak += int(value[0] >> (8 - shift))
-			}
+		peak <<= shift
+	}
+
+	if tools.Strcasecmp(desc, "album") {
+		album = true
+	} else if !tools.Strcasecmp(desc, "track") {
+		// Only accept non-track values if we don't have any previous
+		// value.
+		if id3.TrackGain != 0 {
+			return pos, nil
 		}
+	}
+
+	ParseReplayGainInt(album, int64(gain), int64(peak*2), id3)
+
+	return pos, nil
+}
+
+// parse a unique file identifier: the owner, e.g. "http://musicbrainz.org",
+// and the identifier
+func ParseMbtid(id3 *Mp3Entry, tag []byte, pos int) (int, error) {
+	owner, id := NextId3String(0, tag)
+
+	field, ok := id3Field("UFID:" + common.IsoDecode(owner, -1))
+	if ok {
+		SetMusicBrainzId(id3, field, string(id))
+	}
+
+	return pos, nil
+}
+
+// Roles of TIPL frames that have a name of their own in FieldTable
+var id3CreditRoles = map[string]string{
+	"mix":    string(FieldMixer),
+	"dj-mix": "djmixer",
+}
+
+// parse a list of involved people: pairs of a role and a name
+func ParseCredits(id3 *Mp3Entry, tag []byte, pos int) (int, error) {
+	parseId3Credits(id3, tag, false)
+	return pos, nil
+}
+
+// parse a list of musicians: pairs of an instrument and a name
+func ParseMusicianCredits(id3 *Mp3Entry, tag []byte, pos int) (int, error) {
+	parseId3Credits(id3, tag, true)
+	return pos, nil
+}
 
-		if tools.Strcasecmp(desc, "album") {
-			album = true
-		} else if !tools.Strcasecmp(desc, "track") {
-			// Only accept non-track values if we don't have any previous
-			// value.
-			if id3.TrackGain != 0 {
-				return startPos, nil
+func parseId3Credits(id3 *Mp3Entry, tag []byte, musicians bool) {
+	strs := strings.Split(string(tag), "\x00")
+	for i := 0; i+1 < len(strs); i += 2 {
+		credit := Credit{Role: string(FieldPerformer), Name: strs[i+1], Instrument: strs[i]}
+		if !musicians {
+			role := strings.ToLower(strings.TrimSpace(strs[i]))
+			if r, ok := id3CreditRoles[role]; ok {
+				role = r
 			}
+			credit = Credit{Role: role, Name: strs[i+1]}
 		}
+		AddCredit(id3, credit)
+	}
+}
+
+// parse the podcast flag of iTunes. Its value doesn't matter, the frame
+// marks a podcast episode.
+func ParsePodcast(id3 *Mp3Entry, tag []byte, pos int) (int, error) {
+	SetPodcast(id3)
+	return pos, nil
+}
 
-		ParseReplayGainInt(album, int64(gain), int64(peak*2), id3)
+// parse the offset of the next tag, counted from the end of this one
+func ParseSeek(id3 *Mp3Entry, tag []byte, pos int) (int, error) {
+	if len(tag) >= 4 {
+		id3.id3v2Seek = int64(Bytes2Int(tag[0], tag[1], tag[2], tag[3]))
 	}
 
-	return startPos, nil
+	return pos, nil
 }
 
-func ParseMbtid(id3 *Mp3Entry, tag []byte, pos int) (int, error) {
-	str := tools.CString(tag)
-	descLen := len(str)
-	// DEBUGF("MBID len: %d\n", desc_len);*/
-	// Musicbrainz track IDs are always 36 chars long
-	mbtidLen := 36
-
-	if Id3V2BufSize-len(tag)+descLen+2 < pos {
-		value := tools.CString(tag[descLen+1:])
-
-		if tools.Strcasecmp(str, "http://musicbrainz.org") {
-			if len(value) == mbtidLen {
-				id3.mbTrackId = value
-				return pos + mbtidLen + 1, nil
-			}
+// parse unsynchronised lyrics: text encoding, language, content description
+// and the lyrics. Only the first lyrics frame is used.
+func ParseLyrics(id3 *Mp3Entry, tag []byte, pos int) (int, error) {
+	if id3.Lyrics.Text != "" || len(tag) < 4 {
+		return pos, nil
+	}
+
+	encoding := tag[0]
+	desc, text := NextId3String(encoding, tag[4:])
+	text, _ = NextId3String(encoding, text)
+
+	_, value := DecodeId3String(encoding, text)
+	if value = NormalizeLyrics(value); value == "" {
+		return pos, nil
+	}
+
+	id3.Lyrics.Text = value
+	id3.Lyrics.Language = id3Language(tag[1:4])
+	_, id3.Lyrics.Description = DecodeId3String(encoding, desc)
+
+	return pos, nil
+}
+
+// parse synchronised lyrics: text encoding, language, time stamp format,
+// content type and content description, followed by the lyrics as text and
+// time stamp pairs. Only the first synchronised lyrics frame is used.
+func ParseSyncedLyrics(id3 *Mp3Entry, tag []byte, pos int) (int, error) {
+	if len(id3.Lyrics.Synced) > 0 || len(tag) < 6 {
+		return pos, nil
+	}
+
+	encoding := tag[0]
+	timeFormat := tag[4]
+	if timeFormat != LyricsTimeMpegFrames && timeFormat != LyricsTimeMs {
+		return pos, errors.Errorf("unknown lyrics time stamp format %d", timeFormat)
+	}
+
+	desc, data := NextId3String(encoding, tag[6:])
+
+	var synced []SyncedLyric
+	for len(data) > 0 {
+		var text []byte
+		text, data = NextId3String(encoding, data)
+		if len(data) < 4 {
+			break
 		}
+
+		_, value := DecodeId3String(encoding, text)
+		synced = append(synced, SyncedLyric{
+			Time: uint64(GetLongBE(data)),
+			Text: value,
+		})
+		data = data[4:]
+	}
+
+	if len(synced) == 0 {
+		return pos, nil
+	}
+
+	sort.SliceStable(synced, func(i, j int) bool {
+		return synced[i].Time < synced[j].Time
+	})
+
+	id3.Lyrics.Synced = synced
+	id3.Lyrics.TimeFormat = timeFormat
+	id3.Lyrics.SyncedLanguage = id3Language(tag[1:4])
+	_, id3.Lyrics.SyncedDescription = DecodeId3String(encoding, desc)
+
+	return pos, nil
+}
+
+// Get the three character language code of a frame, "" if it isn't set
+func id3Language(lang []byte) string {
+	return strings.TrimRight(common.IsoDecode(lang, -1), "\x00 ")
+}
+
+// parse a popularimeter: email address, rating and an optional play counter.
+// The first rating found is used for the entry, see ScaleRating.
+func ParsePopm(id3 *Mp3Entry, tag []byte, pos int) (int, error) {
+	email, rest := NextId3String(0, tag)
+	if len(rest) < 1 {
+		return pos, nil
+	}
+
+	popm := Popularimeter{
+		Email:   common.IsoDecode(email, -1),
+		Rating:  rest[0],
+		Counter: id3Counter(rest[1:]),
+	}
+	id3.Popularimeters = append(id3.Popularimeters, popm)
+
+	if id3.Rating == 0 && popm.Rating != 0 {
+		id3.Rating = ScaleRating(float64(popm.Rating), RatingScalePopm)
+	}
+	if int64(popm.Counter) > id3.PlayCount {
+		id3.PlayCount = int64(popm.Counter)
+	}
+
+	return pos, nil
+}
+
+// parse a play counter
+func ParsePlayCount(id3 *Mp3Entry, tag []byte, pos int) (int, error) {
+	if count := int64(id3Counter(tag)); count > id3.PlayCount {
+		id3.PlayCount = count
 	}
 
 	return pos, nil
 }
 
-// parse user defined text, looking for album artist and replaygain
-// information.
+// Counters are at least 32 bits, and get longer when they overflow
+func id3Counter(b []byte) (counter uint64) {
+	for _, c := range b {
+		counter = counter<<8 | uint64(c)
+	}
+	return
+}
+
+// parse user defined text, looking for the fields of FieldTable, ratings
+// and replaygain information. tag holds the description and the values,
+// separated by nuls.
 func ParseUser(id3 *Mp3Entry, tag []byte, pos int) (int, error) {
-	var value string
-	str := tools.CString(tag)
-	descLen := len(tag)
-	length := 0
-
-	if Id3V2BufSize-len(tag)+descLen+2 < pos {
-		// At least part of the value was read, so we can safely try to
-		// parse it
-		value = tools.CString(tag[descLen+1:])
-
-		if tools.Strcasecmp(str, "ALBUM ARTIST") {
-			length = len(value) + 1
-			id3.AlbumArtist = value
-		} else {
-			// Call parse_replaygain().
-			ParseReplayGain(str, value, id3)
-		}
+	// The description, followed by one or more values
+	strs := strings.Split(string(tag), "\x00")
+	if len(strs) < 2 {
+		return pos, nil
+	}
+	desc := strs[0]
+	value := strs[1]
+
+	field, _ := id3Field("TXXX:" + desc)
+	known := false
+	for _, v := range strs[1:] {
+		known = SetField(id3, field, v) || known
 	}
 
-	return Id3V2BufSize - len(tag) + length, nil
+	if !known && !ParseRatingTag(desc, value, id3) {
+		// Call parse_replaygain().
+		ParseReplayGain(desc, value, id3)
+	}
+
+	return pos, nil
 }
 
 // parse embed albumart
@@ -387,32 +604,30 @@ func ParseAlbumArt(id3 *Mp3Entry, tag []byte, pos int) (int, error) {
 		return pos, nil
 	}
 
-	// we currently don't support unsynchronizing albumart
-	if id3.AlbumArt.TypeAA == AaTypeUnsync {
+	id3.AlbumArt.TypeAA = AaTypeUnknown
+
+	if len(tag) < 5 {
 		return pos, nil
 	}
 
-	id3.AlbumArt.TypeAA = AaTypeUnknown
-
 	start := tag
+	encoding := tag[0]
 	// skip text encoding
 	tag = tag[1:]
 
-	if string(tag[:6]) == "image/" {
+	if bytes.HasPrefix(tag, []byte("image/")) {
 		// ID3 v2.3+
-		tag = tag[6:]
-		if string(tag[:4]) == "jpeg" {
-			id3.AlbumArt.TypeAA = AaTypeJpg
-			tag = tag[5:]
-		} else if string(tag[:3]) == "jpg" {
+		mime := tools.CString(tag)
+		switch mime {
+		case "image/jpeg", "image/jpg":
 			// image/jpg is technically invalid, but it does occur in
 			// the wild
 			id3.AlbumArt.TypeAA = AaTypeJpg
-			tag = tag[4:]
-		} else if string(tag[:3]) == "png" {
+		case "image/png":
 			id3.AlbumArt.TypeAA = AaTypePng
-			tag = tag[4:]
 		}
+		tag = tag[len(mime):]
+		tag = tag[id3TerminatorLen(0, tag):]
 	} else {
 		// ID3 v2.2
 		if string(tag[:3]) == "JPG" {
@@ -423,71 +638,109 @@ func ParseAlbumArt(id3 *Mp3Entry, tag []byte, pos int) (int, error) {
 		tag = tag[3:]
 	}
 
-	if id3.AlbumArt.TypeAA != AaTypeUnknown {
+	if id3.AlbumArt.TypeAA != AaTypeUnknown && len(tag) > 0 {
 		// skip picture type
 		tag = tag[1:]
 		// skip description
-		for i := 0; i < len(tag); i++ {
-			if i == len(tag)-1 {
-				tag = tag[i+1:]
-			} else if tag[i] == 0 {
-				tag = tag[i+1:]
-			}
+		if strs := SplitId3Strings(encoding, tag); len(strs) > 0 {
+			tag = tag[len(strs[0]):]
+			tag = tag[id3TerminatorLen(encoding, tag):]
 		}
 		// fixup offset&size for image data
-		id3.AlbumArt.Pos += len(start) - len(tag)
+		if id3.AlbumArt.Data != nil {
+			id3.AlbumArt.Data = tag
+		} else {
+			id3.AlbumArt.Pos += len(start) - len(tag)
+		}
 		id3.AlbumArt.Size -= len(start) - len(tag)
 		// check for malformed tag with no picture data
 		id3.HasAlbumArt = id3.AlbumArt.Size != 0
 	}
 
-	// return bufferpos as we didn't store anything in id3v2buf
 	return pos, nil
 }
 
-// parse numeric genre from string, version 2.2 and 2.3
+// Parse the content type frame into id3.Genre and id3.Values.Genre. In
+// version 2.4 the frame is a list of strings, either numbers or text, older
+// versions use a single string of genre numbers in parentheses optionally
+// followed by a refinement, e.g. "(13)(17)Pop Rock". Both forms turn up in
+// tags of either version.
 func ParseGenre(id3 *Mp3Entry, tag []byte, pos int) (int, error) {
-	toNum := func() (int, error) {
-		for i := 0; i < len(tag); i++ {
-			if tag[i] == 0 || !unicode.IsNumber(rune(tag[i])) {
-				return strconv.Atoi(string(tag[:i]))
-			}
+	for _, value := range strings.Split(string(tag), "\x00") {
+		for _, genre := range ParseId3Genres(value) {
+			AddTagValue(id3, &id3.Genre, genre)
 		}
-		return strconv.Atoi(string(tag))
 	}
 
-	if id3.Id3Version == Id3Ver2p4 {
-		// In version 2.4 and up, there are no parentheses, and the genre frame
-		// is a list of strings, either numbers or text.
+	return pos, nil
+}
 
-		// Is it a number?
-		if unicode.IsNumber(rune(tag[0])) {
-			g, err := toNum()
-			if err != nil {
-				return 0, err
+// Resolve one ID3 content type string into its list of genre names. Numbers
+// are looked up in Genres, "RX" and "CR" mean remix and cover, and "((" at
+// the start of the text escapes a genre name that starts with a parenthesis.
+func ParseId3Genres(value string) (genres []string) {
+	add := func(genre string) {
+		for _, g := range genres {
+			if strings.EqualFold(g, genre) {
+				return
 			}
+		}
+		genres = append(genres, genre)
+	}
 
-			id3.Genre = Id3GetNumGenre(uint(g))
-			return pos, nil
-		} else {
-			id3.Genre = tools.CString(tag)
-			return pos + len(id3.Genre) + 1, nil
+	for strings.HasPrefix(value, "(") {
+		if strings.HasPrefix(value, "((") {
+			value = value[1:]
+			break
 		}
-	} else {
-		if tag[0] == '(' && tag[1] != '(' {
-			tag = tag[1:]
-			g, err := toNum()
-			if err != nil {
-				return 0, err
-			}
 
-			id3.Genre = Id3GetNumGenre(uint(g))
-			return pos, nil
-		} else {
-			id3.Genre = tools.CString(tag)
-			return pos + len(id3.Genre) + 1, nil
+		end := strings.IndexByte(value, ')')
+		if end < 0 {
+			break
 		}
+
+		genre, ok := id3GenreRef(value[1:end])
+		if !ok {
+			break
+		}
+		if genre != "" {
+			add(genre)
+		}
+		value = value[end+1:]
 	}
+
+	// Whatever is left is the refinement, or the genre itself in version 2.4
+	if value = strings.TrimSpace(value); value != "" {
+		if genre, ok := id3GenreRef(value); !ok {
+			add(value)
+		} else if genre != "" {
+			add(genre)
+		}
+	}
+
+	return
+}
+
+// Resolve a genre reference, a number or "RX" or "CR". Returns false if ref
+// is none of them, and an empty name for unknown numbers.
+func id3GenreRef(ref string) (string, bool) {
+	switch ref {
+	case "RX":
+		return "Remix", true
+	case "CR":
+		return "Cover", true
+	}
+
+	if ref == "" || strings.TrimLeft(ref, "0123456789") != "" {
+		return "", false
+	}
+
+	n, err := strconv.ParseUint(ref, 10, 16)
+	if err != nil {
+		return "", true
+	}
+
+	return Id3GetNumGenre(uint(n)), true
 }
 
 func parseId3Num(i *int, tag []byte) (err error) {
@@ -508,18 +761,35 @@ func parseId3Num(i *int, tag []byte) (err error) {
 	return
 }
 
-// parse numeric value from string
+// parse numeric value and the optional total after a slash from string,
+// unless it is known already
 func ParseTrackNum(id3 *Mp3Entry, tag []byte, pos int) (int, error) {
+	if _, total := ParseTagNumber(tools.CString(tag)); total > 0 && id3.TrackTotal == 0 {
+		id3.TrackTotal = total
+	}
+	if id3.TrackNum != 0 {
+		return pos, nil
+	}
 	return pos, parseId3Num(&id3.TrackNum, tag)
 }
 
-// parse numeric value from string
+// parse numeric value and the optional total after a slash from string,
+// unless it is known already
 func ParseDiscNum(id3 *Mp3Entry, tag []byte, pos int) (int, error) {
+	if _, total := ParseTagNumber(tools.CString(tag)); total > 0 && id3.DiscTotal == 0 {
+		id3.DiscTotal = total
+	}
+	if id3.DiscNum != 0 {
+		return pos, nil
+	}
 	return pos, parseId3Num(&id3.DiscNum, tag)
 }
 
-// parse numeric value from string
+// parse numeric value from string, unless it is known already
 func ParseYearNum(id3 *Mp3Entry, tag []byte, pos int) (int, error) {
+	if id3.Year != 0 {
+		return pos, nil
+	}
 	return pos, parseId3Num(&id3.Year, tag)
 }
 
@@ -554,6 +824,9 @@ func GetId3v1Len(file *tools.File) (int64, error) {
 	return 128, nil
 }
 
+// Get the size of the ID3v2 tag at the start of a file, as stored in its
+// header: without the header and the footer. See GetId3v2TagLen for the
+// number of bytes the tag takes up.
 func GetId3v2Len(file *tools.File) (int64, error) {
 	defer func() {
 		_, _ = file.Seek(0, io.SeekStart)
@@ -583,476 +856,663 @@ func GetId3v2Len(file *tools.File) (int64, error) {
 	return int64(Unsync(buf[0], buf[1], buf[2], buf[3])), nil
 }
 
+// Get the total length of an ID3v2 tag starting at pos, including its
+// header and footer. Returns 0 if there is no tag at pos.
+func GetId3v2TagLen(file *tools.File, pos int64) (int64, error) {
+	defer func() {
+		_, _ = file.Seek(pos, io.SeekStart)
+	}()
+
+	var buf [10]byte
+
+	_, err := file.Seek(pos, io.SeekStart)
+	if err != nil {
+		return 0, errors.Wrap(err, 0)
+	}
+
+	rd, err := file.Read(buf[:])
+	if err != nil {
+		return 0, errors.Wrap(err, 0)
+	} else if rd != len(buf) {
+		return 0, errors.Errorf("failed to read id3v2 length, got %d bytes", rd)
+	} else if "ID3" != string(buf[:3]) {
+		return 0, nil
+	}
+
+	offset := int64(Unsync(buf[6], buf[7], buf[8], buf[9])) + 10
+
+	// Add the footer, if present
+	if buf[5]&0x10 != 0 {
+		offset += 10
+	}
+
+	return offset, nil
+}
+
+// Look for an ID3v2.4 tag appended to the data ending at end, identified
+// by its "3DI" footer. Returns the position of the tag header and the total
+// length of the tag, or 0, 0 if there is none.
+func GetAppendedId3v2(file *tools.File, end int64) (int64, int64, error) {
+	var buf [10]byte
+
+	if end < 2*int64(len(buf)) {
+		return 0, 0, nil
+	}
+
+	if _, err := file.Seek(end-int64(len(buf)), io.SeekStart); err != nil {
+		return 0, 0, errors.Wrap(err, 0)
+	}
+
+	if rd, err := file.Read(buf[:]); err != nil {
+		return 0, 0, errors.Wrap(err, 0)
+	} else if rd != len(buf) || string(buf[:3]) != "3DI" {
+		return 0, 0, nil
+	}
+
+	length := int64(Unsync(buf[6], buf[7], buf[8], buf[9])) + 20
+	if length > end {
+		return 0, 0, nil
+	}
+
+	// The header is a copy of the footer, with a different identifier
+	pos := end - length
+	if n, err := GetId3v2TagLen(file, pos); err != nil {
+		return 0, 0, err
+	} else if n != length {
+		return 0, 0, nil
+	}
+
+	return pos, length, nil
+}
+
 func Unsync(b0, b1, b2, b3 byte) uint64 {
 	return uint64(b0&0x7F)<<(3*7) | uint64(b1&0x7F)<<(2*7) | uint64(b2&0x7F)<<(1*7) | uint64(b3&0x7F)<<(0*7)
 }
 
-// Sets the title of an MP3 entry based on its ID3v2 tag.
+// Sets the title of an MP3 entry based on its ID3v2 tags.
 //
-// Arguments: file - the MP3 file to scan for a ID3v2 tag
+// Arguments: file - the MP3 file to scan for ID3v2 tags
 //            entry - the entry to set the title in
 //
-// Returns: true if a title was found and created, else false
-//
-// Assumes that the offset of file is at the start of the ID3 header.
-// (if the header is at the begining of the file getid3v2len() will ensure this.)
+// The values the tags of a previous file left in the entry are cleared
+// first, see ReadId3v2Tags for the fields that must already be set.
 func SetId3v2Title(fd *tools.File, id3 *Mp3Entry) (err error) {
+	clearTagFields(id3)
+
+	return ReadId3v2Tags(fd, id3)
+}
+
+// Clear the fields the tags of a previous file may have left in an entry
+// that is reused. The values of the caller, like the rating and the resume
+// position, are kept.
+func clearTagFields(id3 *Mp3Entry) {
 	id3.HasAlbumArt = false
+	id3.TrackNum = 0
+	id3.TrackTotal = 0
+	id3.TrackString = ""
+	id3.DiscNum = 0
+	id3.DiscTotal = 0
+	id3.DiscString = ""
+	id3.Year = 0
+	id3.ReleaseDate = TagDate{}
+	id3.OriginalDate = TagDate{}
+	id3.Title = ""
+	id3.Artist = ""
+	id3.Album = ""
+	id3.Values = Mp3Values{}
+	id3.FieldSources = nil
+	id3.Popularimeters = nil
+	id3.Chapters = nil
+	id3.ChapterTocs = nil
+}
+
+// Read the ID3v2 tag SkipId3v2 found in front of a non-MP3 stream. Values
+// that are set already are kept, see ReadTagBlocks for merging the tag with
+// the native tags of the stream. The file position is restored afterwards.
+func ReadLeadingId3v2Tag(fd *tools.File, id3 *Mp3Entry) error {
+	if id3.FirstFrameOffset == 0 {
+		return nil
+	}
+
+	pos, err := fd.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+	if _, err = fd.Seek(0, io.SeekStart); err != nil {
+		return errors.Wrap(err, 0)
+	}
 
-	if id3.Id3v2len < 10 {
+	id3.Id3v2len = uint64(id3.FirstFrameOffset)
+	if err = ReadId3v2Tag(fd, id3, id3.Id3v2len); err != nil {
+		return err
+	}
+
+	if _, err = fd.Seek(pos, io.SeekStart); err != nil {
+		return errors.Wrap(err, 0)
+	}
+	return nil
+}
+
+// Read the ID3v2 tag of length tagLen, starting at the current offset of
+// fd, into id3. Values that are already set are kept, which allows merging
+// several tags into one entry.
+func ReadId3v2Tag(fd *tools.File, id3 *Mp3Entry, tagLen uint64) error {
+	id3.id3v2Seek = 0
+
+	if tagLen < 10 {
 		// Bail out if the tag is shorter than 10 bytes
-		return
+		return nil
 	}
 
-	// Read the ID3 tag version from the header.
-	// Assumes fd is already at the begining of the header
-	var header [10]byte
-	if rd, err := fd.Read(header[:]); err != nil {
+	pos, err := fd.Seek(0, io.SeekCurrent)
+	if err != nil {
 		return errors.Wrap(err, 0)
-	} else if rd != len(header) {
-		return errors.New("failed to read id3 tag version")
-	}
-
-	// Get the total ID3 tag size
-	size := id3.Id3v2len - 10
-
-	version := Id3Version(header[3])
-	var minFrameSize int
-	switch version {
-	case 2:
-		version = Id3Ver2p2
-		minFrameSize = 8
-	case 3:
-		version = Id3Ver2p3
-		minFrameSize = 12
-	case 4:
-		version = Id3Ver2p4
-		minFrameSize = 12
-	default:
-		return errors.New("unsupported id3 version")
 	}
 
-	id3.Id3Version = version
-	id3.TrackNum = 0
-	id3.DiscNum = 0
-	id3.Year = 0
-	id3.Title = ""  // FIX ME incomplete // why?
-	id3.Artist = "" // FIX ME incomplete // why?
-	id3.Album = ""  // FIX ME incomplete // why?
-
-	globalFlags := header[5]
-
-	// Skip the extended header if it is present
-	if globalFlags&0x40 != 0 {
-		if version == Id3Ver2p3 {
-			if rd, err := fd.Read(header[:]); err != nil {
-				return errors.Wrap(err, 0)
-			} else if rd != len(header) {
-				return errors.New("error reading id3 header")
+	tag, err := ReadId3v2Frames(fd, pos)
+	if err != nil {
+		return err
+	}
+
+	id3.Id3Version = tag.Version
+
+	for i := range tag.Frames {
+		ParseId3v2Frame(id3, &tag.Frames[i])
+	}
+
+	SetId3v2Dates(id3, tag.Frames)
+	SetId3v2Work(id3, tag.Frames)
+	SortChapters(id3)
+
+	return nil
+}
+
+// Fill in id3 from a single frame of an ID3v2 tag.
+//
+// For each frame. we will iterate over the list of supported tags, and read
+// the tag into entry's buffer. All tags will be kept as strings, for cases
+// where a number won't do, e.g., YEAR: "circa 1765", "1790/1977"
+// (composed/performed), "28 Feb 1969" TRACK: "1/12", "1 of 12", GENRE:
+// "Freeform genre name" Text is more flexible, and as the main use of id3
+// data is to display it, converting it to an int just means reconverting to
+// display it, at a runtime cost.
+//
+// For tags that the current code does convert to ints, a post processing
+// function will be called via a pointer to function.
+func ParseId3v2Frame(id3 *Mp3Entry, frame *Id3Frame) {
+	if frame.Encrypted || len(frame.Data) == 0 {
+		return
+	}
+
+	switch frame.NormalizedId {
+	case "CHAP":
+		ParseChapter(id3, frame)
+		return
+	case "CTOC":
+		ParseChapterToc(id3, frame)
+		return
+	case "TIT1":
+		// Depends on the other frames, see SetId3v2Work
+		return
+	case "COMM":
+		ParseComment(id3, frame)
+		return
+	}
+
+	for _, tr := range TagList {
+		// Only ID3_VER_2_2 uses frames with three-character names, so the
+		// id length always matches the tag version.
+		if frame.Id != tr.Tag {
+			continue
+		}
+
+		var ptag *string
+		if tr.Offset != nil {
+			ptag = tr.Offset(id3)
+		}
+
+		tag := frame.Data
+
+		// Attempt to parse Unicode strings only if the tag contents aren't
+		// binary
+		if !tr.Binary {
+			encoding := tag[0]
+			data := tag[1:]
+
+			var strs []string
+			var raw [][]byte
+			for _, str := range SplitId3Strings(encoding, data) {
+				_, s := DecodeId3String(encoding, str)
+				strs = append(strs, s)
+				raw = append(raw, str)
 			}
 
-			// The 2.3 extended header size doesn't include the header size
-			// field itself. Also, it is not unsynched.
-			frameLen := Bytes2Int(header[0], header[1], header[2], header[3]) + 4
+			if frame.NormalizedId == "TXXX" && len(strs) >= 2 && strings.HasPrefix(strs[0], "CUESHEET") {
+				// Is it an embedded cuesheet? It can only be read if the
+				// value appears in the file as is.
+				offset := 1 + len(raw[0])
+				offset += id3TerminatorLen(encoding, tag[offset:])
+				if charEnc, _ := DecodeId3String(encoding, raw[1]); charEnc > 0 && frame.DataOffset >= 0 {
+					id3.HasEmbeddedCueSheet = true
+					id3.EmbeddedCuesheet.Pos = int(frame.DataOffset) + offset
+					id3.EmbeddedCuesheet.Size = len(tag) - offset
+					id3.EmbeddedCuesheet.Encoding = charEnc
+				}
+				return
+			}
 
-			// Skip the rest of the header
-			if _, err = fd.Seek(int64(frameLen)-10, io.SeekCurrent); err != nil {
-				return errors.Wrap(err, 0)
+			if len(strs) == 0 {
+				return
+			}
+
+			// remove trailing spaces
+			for i := range strs {
+				strs[i] = strings.TrimRightFunc(strs[i], unicode.IsSpace)
 			}
-		}
 
-		if version >= Id3Ver2p4 {
-			if rd, err := fd.Read(header[:4]); err != nil {
-				return errors.Wrap(err, 0)
-			} else if rd != 4 {
-				return errors.New("error reading id3 header")
+			if strings.Join(strs, "") == "" {
+				// Skip empty frames
+				return
 			}
 
-			// The 2.4 extended header size does include the entire header,
-			// so here we can just skip it. This header is unsynched.
-			frameLen := Unsync(header[0], header[1], header[2], header[3])
+			// The post processing functions get all strings of the frame,
+			// separated by a nul
+			tag = []byte(strings.Join(strs, "\x00"))
+		}
 
-			if _, err = fd.Seek(int64(frameLen)-4, io.SeekCurrent); err != nil {
-				return errors.Wrap(err, 0)
+		if ptag != nil {
+			// ID3v2.4 text frames may hold a list of values, and all frames
+			// add theirs to the list of values of the field
+			for _, v := range strings.Split(string(tag), "\x00") {
+				AddTagValue(id3, ptag, v)
 			}
 		}
+
+		// albumart
+		if !id3.HasAlbumArt && frame.NormalizedId == "APIC" {
+			id3.AlbumArt = id3FrameAlbumArt(frame)
+		}
+
+		if tr.PPFunc != nil {
+			// A value that can't be parsed only loses this frame
+			_, _ = tr.PPFunc(id3, tag, 0)
+		}
+		return
 	}
 
-	// Is unsynchronization applied?
-	globalUnsync := globalFlags&0x80 != 0
+	// Text and URL frames without a resolver of their own are looked up in
+	// FieldTable
+	if field, ok := id3Field(frame.NormalizedId); ok {
+		for _, v := range frame.Values {
+			SetField(id3, field, strings.TrimRightFunc(v, unicode.IsSpace))
+		}
+	}
+}
 
-	// We must have at least minframesize bytes left for the
-	// remaining frames to be interesting
-	buffPos, bufSize := 0, Id3V2BufSize
-	for size >= uint64(minFrameSize) && buffPos < bufSize-1 {
-		flags := 0
-		frameLen := int64(0)
+// Position and size of the picture frame data, to be fixed up by
+// ParseAlbumArt
+func id3FrameAlbumArt(frame *Id3Frame) Mp3AlbumArt {
+	aa := Mp3AlbumArt{
+		TypeAA: AaTypeUnknown,
+		Size:   len(frame.Data),
+		Pos:    int(frame.DataOffset),
+	}
+	if frame.DataOffset < 0 {
+		// The image has to be taken from the decoded frame, e.g. because
+		// the unsynchronisation was undone
+		aa.Data = frame.Data
+	}
+	return aa
+}
 
-		// Read frame header and check length
-		if version >= Id3Ver2p3 {
-			var read func(fd *tools.File, b []byte) (int, error)
-			if globalUnsync && version <= Id3Ver2p3 {
-				read = ReadUnsynced
-			} else {
-				read = func(fd *tools.File, buf []byte) (i int, err error) {
-					defer func() {
-						if err != nil {
-							err = errors.Wrap(err, 1)
-						}
-					}()
-					return fd.Read(buf)
-				}
-			}
+// Parse a COMM frame: the language, a short description and the text. The
+// iTunes comments are parsed into id3.ITunes as well.
+func ParseComment(id3 *Mp3Entry, frame *Id3Frame) {
+	if len(frame.Data) < 4 {
+		return
+	}
 
-			if rd, err := read(fd, header[:]); err != nil {
-				return err
-			} else if rd != 10 {
-				return errors.New("failed to read frame size")
-			}
+	encoding := frame.Data[0]
+	desc, text := NextId3String(encoding, frame.Data[4:])
+	text, _ = NextId3String(encoding, text)
 
-			// Adjust for the 10 bytes we read
-			size -= 10
+	comment := Comment{Language: strings.TrimRight(common.IsoDecode(frame.Data[1:4], -1), "\x00 ")}
+	_, comment.Description = DecodeId3String(encoding, desc)
+	_, comment.Text = DecodeId3String(encoding, text)
 
-			flags = int(Bytes2Int(0, 0, header[8], header[9]))
+	ParseITunesComment(comment.Description, comment.Text, id3)
+	AddComment(id3, comment)
+}
 
-			if version >= Id3Ver2p4 {
-				frameLen = int64(Unsync(header[4], header[5], header[6], header[7]))
-			} else {
-				// version .3 files don't use synchsafe ints for
-				// size
-				frameLen = int64(Bytes2Int(header[4], header[5], header[6], header[7]))
-			}
-		} else {
-			if rd, err := fd.Read(header[:6]); err != nil {
-				return errors.Wrap(err, 0)
-			} else if rd != 6 {
-				return errors.New("failed to read id3 frame length")
-			}
+// parse a chapter: element id, start and end time, start and end offset and
+// the sub-frames describing the chapter
+func ParseChapter(id3 *Mp3Entry, frame *Id3Frame) {
+	id, rest := NextId3String(0, frame.Data)
+	if len(rest) < 16 {
+		return
+	}
 
-			// Adjust for the 6 bytes we read
-			size -= 6
+	chapter := Mp3Chapter{
+		Id:          common.IsoDecode(id, -1),
+		Start:       uint64(GetLongBE(rest)),
+		End:         uint64(GetLongBE(rest[4:])),
+		StartOffset: id3ChapterOffset(rest[8:]),
+		EndOffset:   id3ChapterOffset(rest[12:]),
+	}
 
-			frameLen = int64(Bytes2Int(0, header[3], header[4], header[5]))
+	for _, c := range id3.Chapters {
+		if c.Id == chapter.Id {
+			// Element ids are unique, keep the first one
+			return
 		}
+	}
 
-		if frameLen == 0 {
-			if header[0] == 0 && header[1] == 0 && header[2] == 0 {
-				return
-			} else {
-				continue
+	for i := range frame.SubFrames {
+		sub := &frame.SubFrames[i]
+		switch sub.NormalizedId {
+		case "TIT2":
+			chapter.Title = firstId3Value(sub)
+		case "TIT3":
+			chapter.Subtitle = firstId3Value(sub)
+		case "WXXX":
+			if len(sub.Values) >= 2 && chapter.Url == "" {
+				chapter.Url = sub.Values[1]
+			}
+		case "APIC":
+			if !chapter.HasImage && len(sub.Data) > 0 {
+				art := Mp3Entry{AlbumArt: id3FrameAlbumArt(sub)}
+				_, _ = ParseAlbumArt(&art, sub.Data, 0)
+				chapter.HasImage, chapter.Image = art.HasAlbumArt, art.AlbumArt
 			}
 		}
+	}
 
-		unsynch := false
-		var tmp [4]byte
-
-		if flags != 0 {
-			if version >= Id3Ver2p4 {
-				if flags&0x0040 != 0 {
-					// Grouping identity
-					// Skip 1 byte
-					if _, err = fd.Seek(1, io.SeekCurrent); err != nil {
-						return errors.Wrap(err, 0)
-					}
-					frameLen--
-				}
-			} else {
-				if flags&0x0020 != 0 {
-					// Grouping identity
-					// Skip 1 byte
-					if _, err = fd.Seek(1, io.SeekCurrent); err != nil {
-						return errors.Wrap(err, 0)
-					}
-					frameLen--
-				}
-			}
+	id3.Chapters = append(id3.Chapters, chapter)
+}
 
-			if flags&0x000C != 0 {
-				// Compression or encryption
-				// Skip it
-				size -= uint64(frameLen)
-				if _, err = fd.Seek(int64(frameLen), io.SeekCurrent); err != nil {
-					return errors.Wrap(err, 0)
-				}
-				continue
-			}
+// parse a table of contents: element id, flags, the element ids of its
+// children and the sub-frames describing it
+func ParseChapterToc(id3 *Mp3Entry, frame *Id3Frame) {
+	id, rest := NextId3String(0, frame.Data)
+	if len(rest) < 2 {
+		return
+	}
 
-			if flags&0x0002 != 0 {
-				// Unsynchronization
-				unsynch = true
-			}
+	toc := Mp3ChapterToc{
+		Id:       common.IsoDecode(id, -1),
+		TopLevel: rest[0]&0x02 != 0,
+		Ordered:  rest[0]&0x01 != 0,
+	}
 
-			if version >= Id3Ver2p4 {
-				if flags&0x0001 != 0 {
-					// Data length indicator
-					if rd, err := fd.Read(tmp[:]); err != nil {
-						return errors.Wrap(err, 0)
-					} else if rd != len(tmp) {
-						return errors.New("couldn't read id3 data length")
-					}
-
-					// We don't need the data length
-					frameLen -= 4
-				}
-			}
+	for _, t := range id3.ChapterTocs {
+		if t.Id == toc.Id {
+			return
 		}
+	}
 
-		if frameLen == 0 {
-			continue
+	count := int(rest[1])
+	rest = rest[2:]
+	for i := 0; i < count && len(rest) > 0; i++ {
+		var child []byte
+		child, rest = NextId3String(0, rest)
+		toc.Children = append(toc.Children, common.IsoDecode(child, -1))
+	}
+
+	for i := range frame.SubFrames {
+		if frame.SubFrames[i].NormalizedId == "TIT2" {
+			toc.Title = firstId3Value(&frame.SubFrames[i])
 		}
+	}
+
+	id3.ChapterTocs = append(id3.ChapterTocs, toc)
+}
+
+// Put the chapters in playback order: the order given by an ordered top
+// level table of contents, followed by the chapters it doesn't list sorted by
+// their start time.
+func SortChapters(id3 *Mp3Entry) {
+	chapters := map[string]Mp3Chapter{}
+	for _, c := range id3.Chapters {
+		chapters[c.Id] = c
+	}
+	tocs := map[string]Mp3ChapterToc{}
+	for _, t := range id3.ChapterTocs {
+		tocs[t.Id] = t
+	}
 
-		if frameLen < 0 {
+	var ordered []Mp3Chapter
+	listed := map[string]bool{}
+
+	var walk func(id string)
+	walk = func(id string) {
+		if listed[id] {
+			// Broken tags may contain loops
 			return
 		}
+		listed[id] = true
 
-		// Keep track of the remaining frame size
-		totFramLen := frameLen
+		if c, ok := chapters[id]; ok {
+			ordered = append(ordered, c)
+		} else if t, ok := tocs[id]; ok {
+			for _, child := range t.Children {
+				walk(child)
+			}
+		}
+	}
 
-		// If the frame is larger than the remaining buffer space we try
-		// to read as much as would fit in the buffer
-		if frameLen >= int64(bufSize-buffPos) {
-			frameLen = int64(bufSize - buffPos - 1)
+	for _, t := range id3.ChapterTocs {
+		if t.TopLevel && t.Ordered {
+			walk(t.Id)
 		}
+	}
 
-		// Limit the maximum length of an id3 data item to ID3V2_MAX_ITEM_SIZE
-		// bytes. This reduces the chance that the available buffer is filled
-		// by single metadata items like large comments.
-		if Id3V2MaxItemSize < frameLen {
-			frameLen = Id3V2MaxItemSize
+	var rest []Mp3Chapter
+	for _, c := range id3.Chapters {
+		if !listed[c.Id] {
+			rest = append(rest, c)
 		}
+	}
+	sort.SliceStable(rest, func(i, j int) bool {
+		return rest[i].Start < rest[j].Start
+	})
 
-		// Check for certain frame headers
-		//
-		// 'size' is the amount of frame bytes remaining.  We decrement it by
-		// the amount of bytes we read.  If we fail to read as many bytes as
-		// we expect, we assume that we can't read from this file, and bail
-		// out.
-		//
-		// For each frame. we will iterate over the list of supported tags,
-		// and read the tag into entry's buffer. All tags will be kept as
-		// strings, for cases where a number won't do, e.g., YEAR: "circa
-		// 1765", "1790/1977" (composed/performed), "28 Feb 1969" TRACK:
-		// "1/12", "1 of 12", GENRE: "Freeform genre name" Text is more
-		// flexible, and as the main use of id3 data is to display it,
-		// converting it to an int just means reconverting to display it, at a
-		// runtime cost.
-		//
-		// For tags that the current code does convert to ints, a post
-		// processing function will be called via a pointer to function.
-
-		var i int
-		for i = 0; i < len(TagList); i++ {
-			tr := TagList[i]
-			var ptag *string
-			if tr.Offset != nil {
-				ptag = tr.Offset(id3)
-			}
+	id3.Chapters = append(ordered, rest...)
+}
 
-			if tr.Tag == "COM" || tr.Tag == "COMM" {
-				i = i
-			}
+// A chapter byte offset, where all bits set means it isn't used
+func id3ChapterOffset(b []byte) int64 {
+	if offset := GetLongBE(b); offset != 0xFFFFFFFF {
+		return int64(offset)
+	}
+	return -1
+}
 
-			// Only ID3_VER_2_2 uses frames with three-character names.
-			if (version == Id3Ver2p2 && len(tr.Tag) != 3) || version > Id3Ver2p2 && len(tr.Tag) != 4 {
-				continue
+// Set the release and original release dates from the date frames of a
+// tag. The recording time (or the year of ID3v2.3) is used as the release
+// date, the release time only if there is none. ID3v2.3 keeps the day and
+// time of the year in separate TDAT ("DDMM") and TIME ("HHMM") frames.
+func SetId3v2Dates(id3 *Mp3Entry, frames []Id3Frame) {
+	values := make(map[string]string)
+	for i := range frames {
+		frame := &frames[i]
+		switch frame.NormalizedId {
+		case "TDRC", "TDRL", "TDOR", "TDAT", "TIME":
+			if _, ok := values[frame.NormalizedId]; !ok && !frame.Encrypted {
+				values[frame.NormalizedId] = firstId3Value(frame)
 			}
+		}
+	}
 
-			if string(header[:len(tr.Tag)]) == tr.Tag {
-				// found a tag matching one in tagList, and not yet filled
-				tag := make([]byte, frameLen)
+	hadDate := id3.ReleaseDate.Precision != DatePrecisionNone
+	if !SetReleaseDate(id3, values["TDRC"]) {
+		SetReleaseDate(id3, values["TDRL"])
+	}
+	SetOriginalDate(id3, values["TDOR"])
 
-				var read func(fd *tools.File, b []byte) (int, error)
-				if globalUnsync && version <= Id3Ver2p3 {
-					read = ReadUnsynced
-				} else {
-					read = func(fd *tools.File, buf []byte) (i int, err error) {
-						defer func() {
-							if err != nil {
-								err = errors.Wrap(err, 1)
-							}
-						}()
-						return fd.Read(buf)
-					}
-				}
+	date := &id3.ReleaseDate
+	if hadDate || date.Precision != DatePrecisionYear {
+		return
+	}
 
-				var bytesRead int
-				if bytesRead, err = read(fd, tag); err != nil {
-					return err
-				} else if bytesRead != int(frameLen) {
-					return errors.New("failed to read id3 tag")
-				} else {
-					size -= uint64(bytesRead)
-				}
+	// DDMM
+	if v := values["TDAT"]; len(v) == 4 {
+		day, err1 := strconv.Atoi(v[:2])
+		month, err2 := strconv.Atoi(v[2:])
+		if err1 != nil || err2 != nil || day < 1 || day > 31 || month < 1 || month > 12 {
+			return
+		}
+		date.Month, date.Day, date.Precision = month, day, DatePrecisionDay
+	}
 
-				if unsynch || (globalUnsync && version >= Id3Ver2p4) {
-					bytesRead = UnsynchronizeFrame(tag, bytesRead)
-				}
+	// HHMM
+	if v := values["TIME"]; len(v) == 4 && date.Precision == DatePrecisionDay {
+		hour, err1 := strconv.Atoi(v[:2])
+		minute, err2 := strconv.Atoi(v[2:])
+		if err1 != nil || err2 != nil || hour > 23 || minute > 59 {
+			return
+		}
+		date.Hour, date.Minute, date.Precision = hour, minute, DatePrecisionMinute
+	}
+}
 
-				// the COMM frame has a 3 char field to hold an ISO-639-1
-				// language string and an optional short description;
-				// remove them so unicode_munge can work correctly
-				itunesGapless := false
-				if (len(tr.Tag) == 4 && "COMM" == string(header[:4])) || len(tr.Tag) == 3 && "COM" == string(header[:3]) {
-					if bytesRead >= 8 && string(tag[4:8]) == "iTun" {
-						// check for iTunes gapless information
-						if bytesRead >= 12 && string(tag[4:12]) == "iTunSMPB" {
-							itunesGapless = true
-						} else {
-							// ignore other with iTunes tags
-							break
-						}
-					}
-
-					offset := 3 + UnicodeLen(tag[0], tag[4:])
-					if bytesRead > offset {
-						bytesRead -= offset
-						// memmove(tag + 1, tag + 1 + offset, bytesread - 1);
-						copy(tag[1:], tag[1+offset:1+offset+bytesRead-1])
-					}
-				}
+// Set the fields that depend on the other frames of a tag. iTunes 12.5
+// moved the grouping from TIT1 to GRP1, since then TIT1 holds the work. TPE2
+// holds the orchestra of classical music, besides the album artist, so it
+// is also used for the ensemble of tags that have classical fields.
+func SetId3v2Work(id3 *Mp3Entry, frames []Id3Frame) {
+	var tit1, tpe2 []string
+	grp1 := false
+	for i := range frames {
+		frame := &frames[i]
+		switch frame.NormalizedId {
+		case "TIT1":
+			tit1 = append(tit1, frame.Values...)
+		case "TPE2":
+			tpe2 = append(tpe2, frame.Values...)
+		case "GRP1":
+			grp1 = true
+		}
+	}
 
-				// Attempt to parse Unicode string only if the tag contents
-				// aren't binary
-				if !tr.Binary {
-					// UTF-8 could potentially be 3 times larger */
-					// so we need to create a new buffer
-					utf8buf := make([]byte, (3*bytesRead)+1)
-
-					UnicodeMunge(tag, utf8buf, &bytesRead)
-
-					if bytesRead >= bufSize-buffPos {
-						bytesRead = bufSize - buffPos - 1
-					}
-
-					if (len(tr.Tag) == 4 && tools.StrSl(tag, 4) == "TXXX") && (bytesRead >= 14 && tools.StrSl(utf8buf, 8) == "CUESHEET") {
-						// Is it an embedded cuesheet?
-						var charEnc CharacterEncoding
-						// [enc type]+"CUESHEET\0" = 10
-						cuesheetOffset := 10
-						switch tag[0] {
-						case 0x00:
-							charEnc = CharEncIso88591
-						case 0x01:
-							tag = tag[1:]
-							if string(tag[:apps.BomUtf16Size]) == apps.BomUtf16Be {
-								charEnc = CharEncUtf16Be
-							} else if string(tag[:apps.BomUtf16Size]) == apps.BomUtf16Le {
-								charEnc = CharEncUtf16Le
-							}
-							// \1 + BOM(2) + C0U0E0S0H0E0E0T000 = 21
-							cuesheetOffset = 21
-						case 0x02:
-							charEnc = CharEncUtf16Be
-							// \2 + 0C0U0E0S0H0E0E0T00 = 19
-							cuesheetOffset = 19
-						case 0x03:
-							charEnc = CharEncUtf8
-						}
-						if charEnc > 0 {
-							id3.HasEmbeddedCueSheet = true
-							if pos, err := fd.Seek(0, io.SeekCurrent); err != nil {
-								return errors.Wrap(err, 0)
-							} else {
-								id3.EmbeddedCuesheet.Pos = int(pos-frameLen) + cuesheetOffset
-							}
-							id3.EmbeddedCuesheet.Size = int(totFramLen) - cuesheetOffset
-							id3.EmbeddedCuesheet.Encoding = charEnc
-						}
-						break
-					}
-
-					for j := 0; j < bytesRead; j++ {
-						tag[j] = utf8buf[j]
-					}
-
-					// remove trailing spaces
-					for bytesRead > 0 && unicode.IsSpace(rune(tag[bytesRead-1])) {
-						bytesRead--
-					}
-				}
+	field := FieldGrouping
+	if grp1 {
+		field = FieldWork
+	}
+	for _, v := range tit1 {
+		SetField(id3, field, strings.TrimRightFunc(v, unicode.IsSpace))
+	}
 
-				if bytesRead == 0 {
-					// Skip empty frames
-					break
-				}
+	if id3.Work != "" || id3.MovementName != "" || id3.Conductor != "" {
+		for _, v := range tpe2 {
+			SetField(id3, FieldEnsemble, strings.TrimRightFunc(v, unicode.IsSpace))
+		}
+	}
+}
 
-				buffPos += bytesRead + 1
+// Look up a frame in FieldTable, by its ID3v2.4 id, see NormalizeId3FrameId.
+// User defined text frames are also found by their ID3v2.3 name, which
+// may differ.
+func id3Field(id string) (Field, bool) {
+	if field, ok := CanonicalField(id, TagTypeId3v24); ok {
+		return field, true
+	}
+	return CanonicalField(id, TagTypeId3v23)
+}
 
-				// parse the tag if it contains iTunes gapless info
-				if itunesGapless {
-					itunesGapless = false
-					id3.LeadTrim = int(GetiTunesInt32(string(tag), 1))
-					id3.TailTrim = int(GetiTunesInt32(string(tag), 2))
-				}
+// The first string of a text frame, with trailing spaces removed
+func firstId3Value(frame *Id3Frame) string {
+	if len(frame.Values) == 0 {
+		return ""
+	}
+	return strings.TrimRightFunc(frame.Values[0], unicode.IsSpace)
+}
 
-				// Note that parser functions sometimes set *ptag to NULL, so
-				// the "!*ptag" check here doesn't always have the desired
-				// effect. Should the parser functions (parsegenre in
-				// particular) be updated to handle the case of being called
-				// multiple times, or should the "*ptag" check be removed?
-				if ptag != nil && *ptag == "" {
-					*ptag = string(tag[:bytesRead-1])
-				}
+// Walk the ID3v2 tags of a file: the prepended tag, the tags chained to it by
+// SEEK frames, and a tag appended in front of the ID3v1 tag (or the Lyrics3
+// tag). read is called with the position and length of each tag, in that
+// order, and returns the SEEK offset of the tag, or 0 if it has none.
+//
+// id3.Filesize, id3.Id3v1len and id3.Lyrics3len must already be set.
+func WalkId3v2Tags(fd *tools.File, id3 *Mp3Entry, read func(pos, length int64) (int64, error)) error {
+	// Follow the SEEK frames, the offset is counted from the end of the
+	// previous tag
+	pos, last := int64(0), int64(-1)
+	for i := 0; i <= Id3V2MaxChainedTags && uint64(pos) < id3.Filesize; i++ {
+		n, err := GetId3v2TagLen(fd, pos)
+		if err != nil {
+			return err
+		} else if n == 0 {
+			break
+		}
 
-				// albumart
-				if !id3.HasAlbumArt && ((len(tr.Tag) == 4 && string(header[:4]) == "APIC") || (len(tr.Tag) == 3 && string(header[:3]) == "PIC")) {
-					if unsynch || (globalUnsync && version <= Id3Ver2p3) {
-						id3.AlbumArt.TypeAA = AaTypeUnsync
-					} else {
-						if pos, err := fd.Seek(0, io.SeekCurrent); err != nil {
-							return errors.Wrap(err, 0)
-						} else {
-							id3.AlbumArt.Pos = int(pos)
-						}
-						id3.AlbumArt.Size = int(totFramLen)
-						id3.AlbumArt.TypeAA = AaTypeUnknown
-					}
-				}
+		seek, err := read(pos, n)
+		if err != nil {
+			return err
+		}
+		last = pos
+		if seek <= 0 {
+			break
+		}
+		pos += n + seek
+	}
 
-				if tr.PPFunc != nil {
-					buffPos, err = tr.PPFunc(id3, tag, buffPos)
-				}
-				break
-			}
+	// The appended tag is either right before the ID3v1 tag, or before the
+	// Lyrics3 tag
+	end := int64(id3.Filesize - id3.Id3v1len)
+	pos, n, err := GetAppendedId3v2(fd, end)
+	if err != nil {
+		return err
+	}
+	if n == 0 && id3.Lyrics3len > 0 {
+		if pos, n, err = GetAppendedId3v2(fd, end-int64(id3.Lyrics3len)); err != nil {
+			return err
 		}
+	}
 
-		if i == len(TagList) {
-			// no tag in tagList was found, or it was a repeat.
-			// skip it using the total size
+	if n > 0 && pos > last {
+		if _, err = read(pos, n); err != nil {
+			return err
+		}
+	}
 
-			if globalUnsync && version <= Id3Ver2p3 {
-				skip, err := SkipUnsynced(fd, totFramLen)
-				if err != nil {
-					return err
-				}
+	return nil
+}
 
-				size -= skip
-			} else {
-				size -= uint64(totFramLen)
-				if _, err = fd.Seek(totFramLen, io.SeekCurrent); err != nil {
-					return errors.Wrap(err, 0)
-				}
-			}
-		} else {
-			// Seek to the next frame
-			if frameLen < totFramLen {
-				if globalUnsync && version <= Id3Ver2p3 {
-					skip, err := SkipUnsynced(fd, totFramLen-frameLen)
-					if err != nil {
-						return err
-					}
-
-					size -= skip
-				} else {
-					if _, err = fd.Seek(totFramLen-frameLen, io.SeekCurrent); err != nil {
-						return errors.Wrap(err, 0)
-					}
-					size -= uint64(totFramLen - frameLen)
-				}
-			}
+// Read all ID3v2 tags of a file, see WalkId3v2Tags. The values of a tag are
+// merged into id3 without overwriting the ones of the tags before it, and the
+// version is the one of the first tag. The total length of the tags that
+// don't start the file is stored in id3.Id3v2AppendedLen, so it can be
+// excluded from the audio data.
+//
+// id3.Filesize, id3.Id3v1len and id3.Lyrics3len must already be set.
+func ReadId3v2Tags(fd *tools.File, id3 *Mp3Entry) error {
+	id3.Id3v2AppendedLen = 0
+
+	version := Id3Version(0)
+	err := WalkId3v2Tags(fd, id3, func(pos, length int64) (int64, error) {
+		if _, err := fd.Seek(pos, io.SeekStart); err != nil {
+			return 0, errors.Wrap(err, 0)
 		}
+		if err := ReadId3v2Tag(fd, id3, uint64(length)); err != nil {
+			return 0, err
+		}
+
+		if version == 0 {
+			version = id3.Id3Version
+		}
+		if pos > 0 {
+			id3.Id3v2AppendedLen += uint64(length)
+		}
+
+		seek := id3.id3v2Seek
+		id3.id3v2Seek = 0
+		return seek, nil
+	})
+	if version != 0 {
+		id3.Id3Version = version
 	}
-	return
+
+	return err
 }
 
 // Checks to see if the passed in string is a 16-bit wide Unicode v2
@@ -1258,7 +1718,11 @@ func SetId3v1Title(fd *tools.File, id3 *Mp3Entry) error {
 	}
 
 	id3.Id3v1len = 128
-	id3.Id3Version = Id3Ver1p0
+	// An ID3v2 tag is the more interesting one to report
+	isVersion := id3.Id3Version < Id3Ver2p2
+	if isVersion {
+		id3.Id3Version = Id3Ver1p0
+	}
 
 	tags := []*string{&id3.Title, &id3.Artist, &id3.Album}
 	for i := 0; i < len(offsets); i++ {
@@ -1270,37 +1734,32 @@ func SetId3v1Title(fd *tools.File, id3 *Mp3Entry) error {
 		case 1:
 			fallthrough
 		case 2:
-			// kill trailing space in strings
-			for j := 29; j != 0 && (ptr[j] == 0 || ptr[j] == ' '); j-- {
-				ptr = ptr[:j]
-			}
-			// convert string to utf8
-			*tags[i] = common.IsoDecode(ptr[:30], -1)
+			// kill trailing space in strings and convert them to utf8
+			AddTagValue(id3, tags[i], common.IsoDecode(bytes.TrimRight(ptr[:30], "\x00 "), -1))
 		case 3:
-			// kill trailing space in strings
-			for j := 27; j != 0 && (ptr[j] == 0 || ptr[j] == ' '); j-- {
-				ptr = ptr[:j]
-			}
-			// convert string to utf8
-			id3.Comment = common.IsoDecode(ptr[:28], -1)
+			// kill trailing space in strings and convert them to utf8
+			AddComment(id3, Comment{Text: common.IsoDecode(bytes.TrimRight(ptr[:28], "\x00 "), -1)})
 		case 4:
-			id3.YearString = string(ptr[:4])
-			var err error
-			id3.Year, err = strconv.Atoi(id3.YearString)
-			if err != nil {
-				return errors.Wrap(err, 0)
+			// Years of other tags are kept, and so is a tag without one
+			year := string(ptr[:4])
+			if _, err := strconv.Atoi(year); err == nil && SetReleaseDate(id3, year) {
+				AddTagValue(id3, &id3.YearString, year)
 			}
 		case 5:
 			// id3v1.1 uses last two bytes of comment field for track
 			// number: first must be 0 and second is track num
 			if ptr[0] == 0 && ptr[1] != 0 {
-				id3.TrackNum = int(ptr[1])
-				id3.TrackString = fmt.Sprintf("%d", id3.TrackNum)
-				id3.Id3Version = Id3Ver1p1
+				if id3.TrackNum == 0 {
+					id3.TrackNum = int(ptr[1])
+					id3.TrackString = fmt.Sprintf("%d", id3.TrackNum)
+				}
+				if isVersion {
+					id3.Id3Version = Id3Ver1p1
+				}
 			}
 		case 6:
 			// genre
-			id3.Genre = Id3GetNumGenre(uint(ptr[0]))
+			AddTagValue(id3, &id3.Genre, Id3GetNumGenre(uint(ptr[0])))
 		}
 	}
 
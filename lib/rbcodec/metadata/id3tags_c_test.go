@@ -0,0 +1,96 @@
+package metadata
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSyncedLyrics(t *testing.T) {
+	utf16 := "\x01\xff\xfeL\x00a\x00\x00\x00\x00\x00\x00\x05\xff\xfeB\x00\x00\x00\x00\x00\x00\x01"
+
+	tests := []struct {
+		name       string
+		tag        string
+		want       []SyncedLyric
+		wantFormat byte
+		wantLang   string
+		wantDesc   string
+		wantErr    bool
+	}{
+		{"latin-1", "\x00eng\x02\x01desc\x00" + "One\x00\x00\x00\x03\xe8" + "Two\x00\x00\x00\x07\xd0",
+			[]SyncedLyric{{1000, "One"}, {2000, "Two"}}, LyricsTimeMs, "eng", "desc", false},
+		{"unsorted", "\x00eng\x02\x01\x00" + "Two\x00\x00\x00\x07\xd0" + "One\x00\x00\x00\x03\xe8",
+			[]SyncedLyric{{1000, "One"}, {2000, "Two"}}, LyricsTimeMs, "eng", "", false},
+		{"mpeg frames", "\x03xxx\x01\x01\x00" + "Frame\x00\x00\x00\x00\x10",
+			[]SyncedLyric{{16, "Frame"}}, LyricsTimeMpegFrames, "xxx", "", false},
+		{"utf-16 with the description", utf16[:1] + "\x00\x00\x00\x02\x01\xff\xfed\x00\x00\x00" + utf16[1:],
+			[]SyncedLyric{{1, "B"}, {5, "La"}}, LyricsTimeMs, "", "d", false},
+		{"missing time stamp", "\x00eng\x02\x01\x00" + "One\x00\x00\x00",
+			nil, 0, "", "", false},
+		{"unknown time stamp format", "\x00eng\x03\x01\x00" + "One\x00\x00\x00\x00\x01",
+			nil, 0, "", "", true},
+		{"too short", "\x00eng\x02", nil, 0, "", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var id3 Mp3Entry
+			_, err := ParseSyncedLyrics(&id3, []byte(tt.tag), 0)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSyncedLyrics() error = %v", err)
+			}
+			if !reflect.DeepEqual(id3.Lyrics.Synced, tt.want) {
+				t.Errorf("Synced = %q, want %q", id3.Lyrics.Synced, tt.want)
+			}
+			if id3.Lyrics.TimeFormat != tt.wantFormat {
+				t.Errorf("TimeFormat = %d, want %d", id3.Lyrics.TimeFormat, tt.wantFormat)
+			}
+			if id3.Lyrics.SyncedLanguage != tt.wantLang {
+				t.Errorf("SyncedLanguage = %q, want %q", id3.Lyrics.SyncedLanguage, tt.wantLang)
+			}
+			if id3.Lyrics.SyncedDescription != tt.wantDesc {
+				t.Errorf("SyncedDescription = %q, want %q", id3.Lyrics.SyncedDescription, tt.wantDesc)
+			}
+		})
+	}
+}
+
+func TestParseSyncedLyricsKeepsTheFirstFrame(t *testing.T) {
+	var id3 Mp3Entry
+	_, _ = ParseSyncedLyrics(&id3, []byte("\x00eng\x02\x01\x00First\x00\x00\x00\x00\x01"), 0)
+	_, _ = ParseSyncedLyrics(&id3, []byte("\x00deu\x02\x01\x00Second\x00\x00\x00\x00\x01"), 0)
+
+	if len(id3.Lyrics.Synced) != 1 || id3.Lyrics.Synced[0].Text != "First" {
+		t.Errorf("Synced = %q, want the first frame", id3.Lyrics.Synced)
+	}
+}
+
+func TestParseId3Genres(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []string
+	}{
+		{"Rock", []string{"Rock"}},
+		{"17", []string{"Rock"}},
+		{"(17)", []string{"Rock"}},
+		{"(4)Eurodisco", []string{"Disco", "Eurodisco"}},
+		{"(17)(4)", []string{"Rock", "Disco"}},
+		{"(17)Rock", []string{"Rock"}},
+		{"(RX)(CR)", []string{"Remix", "Cover"}},
+		{"((Parenthesized)", []string{"(Parenthesized)"}},
+		{"(17)((Parenthesized)", []string{"Rock", "(Parenthesized)"}},
+		{"(999)", nil},
+		{"(Live)", []string{"(Live)"}},
+		{"(17", []string{"(17"}},
+		{" Jazz ", []string{"Jazz"}},
+		{"", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			if got := ParseId3Genres(tt.value); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseId3Genres(%q) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package metadata
+
+import (
+	"fmt"
+	"github.com/go-errors/errors"
+	"strings"
+)
+
+// Convert the line endings of lyrics to "\n" and strip surrounding blank
+// lines.
+func NormalizeLyrics(lyrics string) string {
+	lyrics = strings.Replace(lyrics, "\r\n", "\n", -1)
+	lyrics = strings.Replace(lyrics, "\r", "\n", -1)
+	return strings.Trim(lyrics, "\n")
+}
+
+// Render the synchronised lyrics of id3 as the contents of an .lrc file.
+//
+// Time stamps counting MPEG frames are converted using the average frame
+// duration of the file. Lyrics where some entries start with a line break
+// hold single syllables, which are joined into lines; otherwise every entry
+// is a line of its own.
+func ExportLrc(id3 *Mp3Entry) (string, error) {
+	lyrics := &id3.Lyrics
+	if len(lyrics.Synced) == 0 {
+		return "", errors.New("no synchronised lyrics")
+	}
+
+	toMs := func(t uint64) uint64 {
+		return t
+	}
+	if lyrics.TimeFormat == LyricsTimeMpegFrames {
+		// Every frame of a stream has the same number of samples
+		if id3.FrameSamples == 0 || id3.Frequency == 0 {
+			return "", errors.New("can't convert mpeg frame time stamps without the frame duration")
+		}
+		toMs = func(t uint64) uint64 {
+			return t * id3.FrameSamples * 1000 / id3.Frequency
+		}
+	}
+
+	syllables := false
+	for _, l := range lyrics.Synced {
+		if strings.HasPrefix(l.Text, "\n") || strings.HasPrefix(l.Text, "\r") {
+			syllables = true
+			break
+		}
+	}
+
+	var b strings.Builder
+
+	header := func(tag, value string) {
+		if value != "" {
+			fmt.Fprintf(&b, "[%s:%s]\n", tag, value)
+		}
+	}
+	header("ti", id3.Title)
+	header("ar", id3.Artist)
+	header("al", id3.Album)
+	header("la", lyrics.SyncedLanguage)
+	if id3.Length > 0 {
+		header("length", fmt.Sprintf("%02d:%02d", id3.Length/60000, id3.Length/1000%60))
+	}
+
+	started := false
+	for _, l := range lyrics.Synced {
+		text := l.Text
+		newLine := !syllables || !started
+		if syllables && (strings.HasPrefix(text, "\n") || strings.HasPrefix(text, "\r")) {
+			text = strings.TrimLeft(text, "\r\n")
+			newLine = true
+		}
+
+		if newLine {
+			if started {
+				b.WriteString("\n")
+			}
+			ms := toMs(l.Time)
+			fmt.Fprintf(&b, "[%02d:%02d.%02d]", ms/60000, ms/1000%60, ms%1000/10)
+			started = true
+		}
+
+		b.WriteString(strings.Replace(NormalizeLyrics(text), "\n", " ", -1))
+	}
+	b.WriteString("\n")
+
+	return b.String(), nil
+}
@@ -0,0 +1,178 @@
+package metadata
+
+import (
+	"bytes"
+	"github.com/go-errors/errors"
+	"io"
+	"rbmetadata-go/firmware/common"
+	"rbmetadata-go/tools"
+	"strconv"
+	"strings"
+)
+
+const (
+	Lyrics3Begin    = "LYRICSBEGIN"
+	Lyrics3v1End    = "LYRICSEND"
+	Lyrics3v2End    = "LYRICS200"
+	Lyrics3SizeLen  = 6
+	Lyrics3FieldLen = 5
+	// Lyrics3 v1 lyrics may not be longer than 5100 bytes
+	Lyrics3v1MaxSize = 5100
+)
+
+// Read a Lyrics3 v1 or v2 tag, which is stored right in front of the ID3v1
+// tag (or at the end of the file). id3.Id3v1len must already be set. The
+// tag length is stored in id3.Lyrics3len, so the block can be excluded from
+// the audio data.
+func ReadLyrics3Tag(fd *tools.File, id3 *Mp3Entry) error {
+	var buf [Lyrics3SizeLen + len(Lyrics3v2End)]byte
+
+	id3.Lyrics3 = Lyrics3Tag{}
+	id3.Lyrics3len = 0
+
+	end := int64(fd.FileSize()) - int64(id3.Id3v1len)
+	if end < int64(len(buf)+len(Lyrics3Begin)) {
+		return nil
+	}
+
+	if _, err := fd.Seek(end-int64(len(buf)), io.SeekStart); err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	if rd, err := fd.Read(buf[:]); err != nil {
+		return errors.Wrap(err, 0)
+	} else if rd < len(buf) {
+		return errors.New("failed to read lyrics3 footer")
+	}
+
+	switch string(buf[Lyrics3SizeLen:]) {
+	case Lyrics3v1End:
+		return readLyrics3v1(fd, id3, end-int64(len(Lyrics3v1End)))
+	case Lyrics3v2End:
+		size, err := strconv.Atoi(string(buf[:Lyrics3SizeLen]))
+		if err != nil {
+			return nil
+		}
+		return readLyrics3v2(fd, id3, end-int64(len(buf)), int64(size))
+	}
+
+	return nil
+}
+
+// Version 1 has no size field, so the start has to be searched for.
+func readLyrics3v1(fd *tools.File, id3 *Mp3Entry, end int64) error {
+	size := int64(Lyrics3v1MaxSize + len(Lyrics3Begin))
+	if size > end {
+		size = end
+	}
+
+	if _, err := fd.Seek(end-size, io.SeekStart); err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	buf := make([]byte, size)
+	if rd, err := fd.Read(buf); err != nil {
+		return errors.Wrap(err, 0)
+	} else if int64(rd) < size {
+		return errors.New("failed to read lyrics3 tag")
+	}
+
+	start := bytes.LastIndex(buf, []byte(Lyrics3Begin))
+	if start < 0 {
+		return nil
+	}
+
+	id3.Lyrics3.Version = 1
+	id3.Lyrics3.Lyrics = lyrics3String(buf[start+len(Lyrics3Begin):])
+	id3.Lyrics3len = uint64(len(buf)-start) + uint64(len(Lyrics3v1End))
+
+	return nil
+}
+
+// Version 2 stores its size (not including the size field and the end
+// marker) in front of the end marker.
+func readLyrics3v2(fd *tools.File, id3 *Mp3Entry, end int64, size int64) error {
+	if size < int64(len(Lyrics3Begin)) || size > end {
+		return nil
+	}
+
+	if _, err := fd.Seek(end-size, io.SeekStart); err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	buf := make([]byte, size)
+	if rd, err := fd.Read(buf); err != nil {
+		return errors.Wrap(err, 0)
+	} else if int64(rd) < size {
+		return errors.New("failed to read lyrics3 tag")
+	}
+
+	if string(buf[:len(Lyrics3Begin)]) != Lyrics3Begin {
+		return nil
+	}
+
+	id3.Lyrics3.Version = 2
+	id3.Lyrics3.Fields = map[string]string{}
+	id3.Lyrics3len = uint64(size) + Lyrics3SizeLen + uint64(len(Lyrics3v2End))
+
+	// Each field is a three character id, a five digit size and the data
+	fields := buf[len(Lyrics3Begin):]
+	for len(fields) >= 3+Lyrics3FieldLen {
+		id := string(fields[:3])
+		ln, err := strconv.Atoi(string(fields[3 : 3+Lyrics3FieldLen]))
+		if err != nil || ln > len(fields)-3-Lyrics3FieldLen {
+			break
+		}
+
+		value := fields[3+Lyrics3FieldLen : 3+Lyrics3FieldLen+ln]
+		fields = fields[3+Lyrics3FieldLen+ln:]
+
+		id3.Lyrics3.Fields[id] = lyrics3String(value)
+	}
+
+	id3.Lyrics3.Lyrics = id3.Lyrics3.Fields["LYR"]
+
+	return nil
+}
+
+// Fill in the entry from a Lyrics3 v2 tag. The extended album, artist and
+// title fields complete the 30 character ID3v1 fields, so they replace
+// values of the ID3v1 tag that are a truncated prefix of them. Values of
+// other tags are kept.
+func SetLyrics3Fields(id3 *Mp3Entry) {
+	AddTagValue(id3, &id3.Lyrics.Text, NormalizeLyrics(id3.Lyrics3.Lyrics))
+
+	if id3.Lyrics3.Version != 2 {
+		return
+	}
+
+	extend := func(field Field, id string) {
+		p := id3.fieldString(field)
+		value := id3.Lyrics3.Fields[id]
+		if value == "" || !strings.HasPrefix(value, *p) {
+			return
+		}
+
+		if source, ok := id3.FieldSources[field]; ok {
+			if source != TagBlockId3v1 {
+				return
+			}
+			delete(id3.FieldSources, field)
+			*p = ""
+			*id3.valuesOf(p) = nil
+		}
+		AddTagValue(id3, p, value)
+	}
+
+	extend(FieldAlbum, "EAL")
+	extend(FieldArtist, "EAR")
+	extend(FieldTitle, "ETT")
+
+	AddComment(id3, Comment{Text: id3.Lyrics3.Fields["INF"]})
+	AddTagValue(id3, &id3.Composer, id3.Lyrics3.Fields["AUT"])
+}
+
+// Lyrics3 text is ISO-8859-1 with CR/LF line endings
+func lyrics3String(b []byte) string {
+	return strings.Replace(common.IsoDecode(b, -1), "\r\n", "\n", -1)
+}
@@ -0,0 +1,68 @@
+package metadata
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// Build a Lyrics3 v2 tag from its fields, with the given size field
+func lyrics3v2(size string, fields ...string) string {
+	tag := Lyrics3Begin + strings.Join(fields, "")
+	if size == "" {
+		size = fmt.Sprintf("%06d", len(tag))
+	}
+	return tag + size + Lyrics3v2End
+}
+
+func lyrics3Field(id, value string) string {
+	return fmt.Sprintf("%s%05d%s", id, len(value), value)
+}
+
+func TestReadLyrics3Tag(t *testing.T) {
+	audio := strings.Repeat("\x00", 200)
+	id3v1 := "TAG" + strings.Repeat("\x00", 125)
+	v2 := lyrics3v2("", lyrics3Field("IND", "00"), lyrics3Field("LYR", "line 1\r\nline 2"), lyrics3Field("ETT", "A long title"))
+	v1 := Lyrics3Begin + "v1 lyrics" + Lyrics3v1End
+
+	tests := []struct {
+		name        string
+		data        string
+		id3v1len    uint64
+		wantVersion int
+		wantLen     uint64
+		wantLyrics  string
+		wantTitle   string
+	}{
+		{"v2", audio + v2, 0, 2, uint64(len(v2)), "line 1\nline 2", "A long title"},
+		{"v2 before id3v1", audio + v2 + id3v1, 128, 2, uint64(len(v2)), "line 1\nline 2", "A long title"},
+		{"v2 size too small", audio + lyrics3v2("000005", lyrics3Field("LYR", "x")), 0, 0, 0, "", ""},
+		{"v2 size too large", audio + lyrics3v2("999999", lyrics3Field("LYR", "x")), 0, 0, 0, "", ""},
+		{"v2 size not a number", audio + lyrics3v2("00x020", lyrics3Field("LYR", "x")), 0, 0, 0, "", ""},
+		{"v2 size off by one", audio + lyrics3v2("000019", lyrics3Field("LYR", "xy")), 0, 0, 0, "", ""},
+		{"v2 field past the end", audio + lyrics3v2("", "LYR00099short", lyrics3Field("ETT", "t")), 0, 2, 48, "", ""},
+		{"v1", audio + v1 + id3v1, 128, 1, uint64(len(v1)), "v1 lyrics", ""},
+		{"none", audio + id3v1, 128, 0, 0, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id3 := Mp3Entry{Id3v1len: tt.id3v1len}
+			if err := ReadLyrics3Tag(openTestFile(t, "test.mp3", []byte(tt.data)), &id3); err != nil {
+				t.Fatal(err)
+			}
+			if id3.Lyrics3.Version != tt.wantVersion {
+				t.Errorf("Version = %d, want %d", id3.Lyrics3.Version, tt.wantVersion)
+			}
+			if id3.Lyrics3len != tt.wantLen {
+				t.Errorf("Lyrics3len = %d, want %d", id3.Lyrics3len, tt.wantLen)
+			}
+			if id3.Lyrics3.Lyrics != tt.wantLyrics {
+				t.Errorf("Lyrics = %q, want %q", id3.Lyrics3.Lyrics, tt.wantLyrics)
+			}
+			if got := id3.Lyrics3.Fields["ETT"]; got != tt.wantTitle {
+				t.Errorf("ETT = %q, want %q", got, tt.wantTitle)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package metadata
+
+import "testing"
+
+func TestExportLrc(t *testing.T) {
+	tests := []struct {
+		name    string
+		id3     Mp3Entry
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "lines",
+			id3: Mp3Entry{Title: "Song", Artist: "Band", Length: 185000, Lyrics: Mp3Lyrics{
+				TimeFormat:     LyricsTimeMs,
+				SyncedLanguage: "eng",
+				Synced:         []SyncedLyric{{0, "First line"}, {61234, "Second\r\nline"}},
+			}},
+			want: "[ti:Song]\n[ar:Band]\n[la:eng]\n[length:03:05]\n" +
+				"[00:00.00]First line\n[01:01.23]Second line\n",
+		},
+		{
+			name: "syllables",
+			id3: Mp3Entry{Lyrics: Mp3Lyrics{
+				TimeFormat: LyricsTimeMs,
+				Synced:     []SyncedLyric{{1000, "Hel"}, {1500, "lo"}, {2000, "\nWorld"}, {2500, " again"}},
+			}},
+			want: "[00:01.00]Hello\n[00:02.00]World again\n",
+		},
+		{
+			name: "mpeg frames",
+			id3: Mp3Entry{FrameSamples: 1152, Frequency: 44100, Lyrics: Mp3Lyrics{
+				TimeFormat: LyricsTimeMpegFrames,
+				Synced:     []SyncedLyric{{0, "Start"}, {2297, "One minute"}},
+			}},
+			want: "[00:00.00]Start\n[01:00.00]One minute\n",
+		},
+		{
+			name: "mpeg frames without the frame duration",
+			id3: Mp3Entry{Lyrics: Mp3Lyrics{
+				TimeFormat: LyricsTimeMpegFrames,
+				Synced:     []SyncedLyric{{10, "Line"}},
+			}},
+			wantErr: true,
+		},
+		{
+			name:    "no synchronised lyrics",
+			id3:     Mp3Entry{Lyrics: Mp3Lyrics{Text: "Unsynced"}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExportLrc(&tt.id3)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("ExportLrc() succeeded")
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("ExportLrc() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package metadata
+
+import "sort"
+
+// The tag blocks a file can have. See MergePolicy for how the fields of
+// several blocks are combined.
+const (
+	// The format's own metadata, like the Vorbis comments of FLAC files or
+	// the atoms of MP4 files
+	TagBlockNative = TagBlock(iota + 1)
+	TagBlockId3v2
+	TagBlockApe
+	TagBlockLyrics3
+	TagBlockId3v1
+)
+
+type TagBlock int
+
+func (block TagBlock) String() string {
+	switch block {
+	case TagBlockNative:
+		return "native"
+	case TagBlockId3v2:
+		return "ID3v2"
+	case TagBlockApe:
+		return "APE"
+	case TagBlockLyrics3:
+		return "Lyrics3"
+	case TagBlockId3v1:
+		return "ID3v1"
+	}
+	return "unknown"
+}
+
+// How the fields of several tag blocks are merged
+const (
+	// A field is taken from the tag block of the highest precedence that
+	// has it
+	MergeByPrecedence = MergeMode(iota)
+	// A field is taken from the first tag block in the file that has it
+	MergeFirstInFile
+)
+
+type MergeMode int
+
+// The tag blocks from the highest precedence to the lowest
+var DefaultTagPrecedence = []TagBlock{
+	TagBlockNative, TagBlockId3v2, TagBlockApe, TagBlockLyrics3, TagBlockId3v1,
+}
+
+// How the parsers merge the fields of files with several tag blocks, e.g.
+// an MP3 file with ID3v2, APE and ID3v1 tags. The zero value merges by
+// DefaultTagPrecedence.
+type MergePolicy struct {
+	Mode MergeMode
+	// The tag blocks by precedence, highest first. Blocks that aren't listed
+	// follow in the order of DefaultTagPrecedence.
+	Precedence []TagBlock
+}
+
+// Get the position of block in the order of precedence
+func (policy MergePolicy) rank(block TagBlock) int {
+	for i, b := range policy.Precedence {
+		if b == block {
+			return i
+		}
+	}
+	for i, b := range DefaultTagPrecedence {
+		if b == block {
+			return len(policy.Precedence) + i
+		}
+	}
+	return len(policy.Precedence) + len(DefaultTagPrecedence)
+}
+
+// A tag block of a file and the function that reads it into the entry
+type TagBlockReader struct {
+	Block TagBlock
+	Read  func() error
+}
+
+// Read the tag blocks of a file, given in the order they appear in it, in
+// the order id3.MergePolicy asks for. The blocks only fill in the fields the
+// ones read before them left empty, and id3.FieldSources records the block
+// each field came from. The lists of values in id3.Values, the play count and
+// the compilation flag come from the same block as the field.
+func ReadTagBlocks(id3 *Mp3Entry, blocks []TagBlockReader) error {
+	if id3.MergePolicy.Mode == MergeByPrecedence {
+		blocks = append([]TagBlockReader(nil), blocks...)
+		sort.SliceStable(blocks, func(i, j int) bool {
+			return id3.MergePolicy.rank(blocks[i].Block) < id3.MergePolicy.rank(blocks[j].Block)
+		})
+	}
+
+	if id3.FieldSources == nil {
+		id3.FieldSources = make(map[Field]TagBlock)
+	}
+
+	for _, b := range blocks {
+		// The block may add values to the lists of the fields that are set
+		// already, restore them afterwards. A block that takes over a field
+		// removes its source.
+		kept := make(map[Field][]string)
+		for field := range id3.FieldSources {
+			if values := id3.valuesOf(id3.fieldString(field)); values != nil {
+				kept[field] = *values
+			}
+		}
+		// Play counts take the highest count and the compilation flag is
+		// set by any value, which is only meant for the frames of one block
+		playCount, compilation := id3.PlayCount, id3.Compilation
+		unsourced := UnsourcedFields(id3)
+
+		if err := b.Read(); err != nil {
+			return err
+		}
+
+		for field, v := range kept {
+			if _, ok := id3.FieldSources[field]; ok {
+				*id3.valuesOf(id3.fieldString(field)) = v
+			}
+		}
+		if _, ok := id3.FieldSources[FieldPlayCount]; ok {
+			id3.PlayCount = playCount
+		}
+		if _, ok := id3.FieldSources[FieldCompilation]; ok {
+			id3.Compilation = compilation
+		}
+		SetFieldSources(id3, b.Block, unsourced)
+	}
+
+	return nil
+}
+
+// Get the fields of id3 that are set but have no source, like the rating
+// the caller took from its database
+func UnsourcedFields(id3 *Mp3Entry) map[Field]bool {
+	fields := make(map[Field]bool)
+	for _, field := range Fields() {
+		if _, ok := id3.FieldSources[field]; !ok && id3.GetField(field) != "" {
+			fields[field] = true
+		}
+	}
+	return fields
+}
+
+// Record block as the source of all fields of id3 that are set and have no
+// source yet, except the ones in skip, which were set before the block was
+// read.
+func SetFieldSources(id3 *Mp3Entry, block TagBlock, skip map[Field]bool) {
+	for _, field := range Fields() {
+		if _, ok := id3.FieldSources[field]; ok || skip[field] || id3.GetField(field) == "" {
+			continue
+		}
+		if id3.FieldSources == nil {
+			id3.FieldSources = make(map[Field]TagBlock)
+		}
+		id3.FieldSources[field] = block
+	}
+}
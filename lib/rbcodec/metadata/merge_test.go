@@ -0,0 +1,141 @@
+package metadata
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReadTagBlocks(t *testing.T) {
+	// The tags of each block, as Vorbis comment names and values
+	type block struct {
+		block TagBlock
+		tags  [][2]string
+	}
+	id3v2 := block{TagBlockId3v2, [][2]string{{"TITLE", "v2"}, {"ARTIST", "A"}, {"ARTIST", "B"}, {"PLAYCOUNT", "5"}}}
+	ape := block{TagBlockApe, [][2]string{{"TITLE", "ape"}, {"ARTIST", "C"}, {"ALBUM", "Ape album"}, {"PLAYCOUNT", "99"}, {"COMPILATION", "1"}}}
+	id3v1 := block{TagBlockId3v1, [][2]string{{"TITLE", "v1"}, {"GENRE", "Rock"}}}
+
+	tests := []struct {
+		name        string
+		policy      MergePolicy
+		blocks      []block
+		want        Mp3Entry
+		wantSources map[Field]TagBlock
+	}{
+		{
+			name:   "default precedence",
+			blocks: []block{id3v2, ape, id3v1},
+			want: Mp3Entry{
+				Title: "v2", Artist: "A", Album: "Ape album", Genre: "Rock",
+				PlayCount: 5, Compilation: true,
+				Values: Mp3Values{Title: []string{"v2"}, Artist: []string{"A", "B"}, Album: []string{"Ape album"}, Genre: []string{"Rock"}},
+			},
+			wantSources: map[Field]TagBlock{
+				FieldTitle: TagBlockId3v2, FieldArtist: TagBlockId3v2, FieldPlayCount: TagBlockId3v2,
+				FieldAlbum: TagBlockApe, FieldCompilation: TagBlockApe, FieldGenre: TagBlockId3v1,
+			},
+		},
+		{
+			name:   "default precedence, ID3v1 first in the file",
+			blocks: []block{id3v1, id3v2},
+			want: Mp3Entry{
+				Title: "v2", Artist: "A", Genre: "Rock", PlayCount: 5,
+				Values: Mp3Values{Title: []string{"v2"}, Artist: []string{"A", "B"}, Genre: []string{"Rock"}},
+			},
+			wantSources: map[Field]TagBlock{
+				FieldTitle: TagBlockId3v2, FieldArtist: TagBlockId3v2, FieldPlayCount: TagBlockId3v2, FieldGenre: TagBlockId3v1,
+			},
+		},
+		{
+			name:   "APE first",
+			policy: MergePolicy{Precedence: []TagBlock{TagBlockApe}},
+			blocks: []block{id3v2, ape, id3v1},
+			want: Mp3Entry{
+				Title: "ape", Artist: "C", Album: "Ape album", Genre: "Rock",
+				PlayCount: 99, Compilation: true,
+				Values: Mp3Values{Title: []string{"ape"}, Artist: []string{"C"}, Album: []string{"Ape album"}, Genre: []string{"Rock"}},
+			},
+			wantSources: map[Field]TagBlock{
+				FieldTitle: TagBlockApe, FieldArtist: TagBlockApe, FieldAlbum: TagBlockApe,
+				FieldPlayCount: TagBlockApe, FieldCompilation: TagBlockApe, FieldGenre: TagBlockId3v1,
+			},
+		},
+		{
+			name:   "first in file",
+			policy: MergePolicy{Mode: MergeFirstInFile, Precedence: []TagBlock{TagBlockApe}},
+			blocks: []block{id3v1, ape, id3v2},
+			want: Mp3Entry{
+				Title: "v1", Artist: "C", Album: "Ape album", Genre: "Rock",
+				PlayCount: 99, Compilation: true,
+				Values: Mp3Values{Title: []string{"v1"}, Artist: []string{"C"}, Album: []string{"Ape album"}, Genre: []string{"Rock"}},
+			},
+			wantSources: map[Field]TagBlock{
+				FieldTitle: TagBlockId3v1, FieldGenre: TagBlockId3v1, FieldArtist: TagBlockApe,
+				FieldAlbum: TagBlockApe, FieldPlayCount: TagBlockApe, FieldCompilation: TagBlockApe,
+			},
+		},
+		{
+			name:        "no blocks",
+			want:        Mp3Entry{},
+			wantSources: map[Field]TagBlock{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id3 := &Mp3Entry{MergePolicy: tt.policy}
+
+			var readers []TagBlockReader
+			for _, b := range tt.blocks {
+				b := b
+				readers = append(readers, TagBlockReader{b.block, func() error {
+					for _, tag := range b.tags {
+						if err := ParseTag(tag[0], tag[1], id3, TagTypeVorbis); err != nil {
+							return err
+						}
+					}
+					return nil
+				}})
+			}
+
+			if err := ReadTagBlocks(id3, readers); err != nil {
+				t.Fatal(err)
+			}
+
+			got := Mp3Entry{
+				Title: id3.Title, Artist: id3.Artist, Album: id3.Album, Genre: id3.Genre,
+				PlayCount: id3.PlayCount, Compilation: id3.Compilation, Values: id3.Values,
+			}
+			if !reflect.DeepEqual(got.Values, tt.want.Values) {
+				t.Errorf("Values = %q, want %q", got.Values, tt.want.Values)
+			}
+			got.Values, tt.want.Values = Mp3Values{}, Mp3Values{}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+			if !reflect.DeepEqual(id3.FieldSources, tt.wantSources) {
+				t.Errorf("FieldSources = %v, want %v", id3.FieldSources, tt.wantSources)
+			}
+		})
+	}
+}
+
+func TestReadTagBlocksKeepsTheCallersValues(t *testing.T) {
+	id3 := &Mp3Entry{Rating: 7}
+
+	err := ReadTagBlocks(id3, []TagBlockReader{
+		{TagBlockId3v2, func() error {
+			return ParseTag("RATING", "2", id3, TagTypeVorbis)
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id3.Rating != 7 {
+		t.Errorf("Rating = %d, want 7", id3.Rating)
+	}
+	if source, ok := id3.FieldSources[FieldRating]; ok {
+		t.Errorf("FieldSources[FieldRating] = %v, want none", source)
+	}
+}
@@ -415,17 +415,57 @@ var AudioFormats = map[CodecType]AfmtEntry{
 	//	ParseFunc: GetAacMetadata,
 	//	ExtList:   []string{"aac"},
 	//},
+	// Standard MIDI File
+	AfmtMidi: {
+		Label:     "MIDI",
+		Filename:  "midi",
+		ParseFunc: GetMidiMetadata,
+		ExtList:   []string{"mid", "midi", "kar", "rmi"},
+	},
+	// AMR-NB (Adaptive Multi-Rate narrowband)
+	AfmtAmrNb: {
+		Label:     "AMR",
+		Filename:  "amr",
+		ParseFunc: GetAmrMetadata,
+		ExtList:   []string{"amr"},
+	},
+	// AMR-WB (Adaptive Multi-Rate wideband)
+	AfmtAmrWb: {
+		Label:     "AMR-WB",
+		Filename:  "amr",
+		ParseFunc: GetAmrMetadata,
+		ExtList:   []string{"awb"},
+	},
+	// PSF family (PlayStation, Saturn, N64, GBA, DS, SNES, ...)
+	AfmtPsf: {
+		Label:     "PSF",
+		Filename:  "psf",
+		ParseFunc: GetPsfMetadata,
+		ExtList: []string{
+			"psf", "minipsf", "psf1", "minipsf1", "psf2", "minipsf2",
+			"ssf", "minissf", "minidsf", "usf", "miniusf", "gsf", "minigsf",
+			"snsf", "minisnsf", "2sf", "mini2sf", "qsf", "miniqsf",
+		},
+	},
 }
 
 func GetShnMetadata(f *tools.File, id3 *Mp3Entry) (err error) {
-	// TO-DO: read the id3v2 header if it exists
 	id3.VBR = true
 	id3.Filesize, err = tools.FileSize(f.Name())
 	if err != nil {
 		return
 	}
 
-	return SkipId3v2(f, id3)
+	if err = SkipId3v2(f, id3); err != nil {
+		return
+	}
+
+	// Shorten has no tags of its own
+	return ReadTagBlocks(id3, []TagBlockReader{
+		{TagBlockId3v2, func() error {
+			return ReadLeadingId3v2Tag(f, id3)
+		}},
+	})
 }
 
 func GetOtherAsapMetadata(f *tools.File, id3 *Mp3Entry) (err error) {
@@ -440,17 +480,27 @@ func GetOtherAsapMetadata(f *tools.File, id3 *Mp3Entry) (err error) {
 	return
 }
 
-// Simple file type probing by looking at the filename extension.
-func ProbeFileFormat(filename string) CodecType {
+// Get the extension of a filename, without the dot. Returns an empty string
+// if there is none.
+func fileExtension(filename string) string {
 	parts := strings.Split(filename, string(os.PathSeparator))
 	filename = parts[len(parts)-1]
 
 	parts = strings.Split(filename, ".")
 	if len(parts) < 2 {
+		return ""
+	}
+
+	return parts[len(parts)-1]
+}
+
+// Simple file type probing by looking at the filename extension.
+func ProbeFileFormat(filename string) CodecType {
+	suffix := fileExtension(filename)
+	if suffix == "" {
 		return AfmtUnknown
 	}
 
-	suffix := parts[len(parts)-1]
 	for i := AfmtUnknown + 1; i < AfmtNumCodecs; i++ {
 		for _, ext := range AudioFormats[i].ExtList {
 			if tools.Strcasecmp(suffix, ext) {
@@ -485,6 +535,29 @@ func Mp3Info(entry *Mp3Entry, filename string) (err error) {
 	return
 }
 
+// Open filename, read its metadata into entry and return the embedded album
+// art, nil if there is none.
+func AlbumArt(entry *Mp3Entry, filename string) (data []byte, err error) {
+	f, err := tools.Open(filename, true, -1)
+	if err != nil {
+		return nil, errors.Wrap(err, 0)
+	}
+
+	defer func() {
+		if err == nil {
+			err = f.Close()
+		} else {
+			_ = f.Close()
+		}
+	}()
+
+	if err = GetMetaData(entry, f); err != nil {
+		return nil, err
+	}
+
+	return ReadAlbumArt(f, entry)
+}
+
 func GetMetaData(id3 *Mp3Entry, file *tools.File) error {
 	// Take our best guess at the codec type based on file extension
 	id3.Codec = ProbeFileFormat(file.Name())
@@ -493,6 +566,9 @@ func GetMetaData(id3 *Mp3Entry, file *tools.File) error {
 	id3.HasEmbeddedCueSheet = false
 	id3.EmbeddedCuesheet.Pos = 0
 
+	id3.FieldSources = nil
+	unsourced := UnsourcedFields(id3)
+
 	entry := AudioFormats[id3.Codec]
 
 	if entry.ParseFunc == nil {
@@ -503,6 +579,12 @@ func GetMetaData(id3 *Mp3Entry, file *tools.File) error {
 		return err
 	}
 
+	// Parsers of formats with a single tag block don't record where the
+	// fields came from, the ones that read several use ReadTagBlocks
+	if id3.FieldSources == nil {
+		SetFieldSources(id3, TagBlockNative, unsourced)
+	}
+
 	id3.Path = file.Name()
 
 	if _, err := file.Seek(0, io.SeekStart); err != nil {
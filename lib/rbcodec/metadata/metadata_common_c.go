@@ -3,6 +3,7 @@ package metadata
 import (
 	"github.com/go-errors/errors"
 	"io"
+	"math"
 	"rbmetadata-go/tools"
 	"strconv"
 	"strings"
@@ -49,7 +50,7 @@ func SkipId3v2(f *tools.File, id3 *Mp3Entry) error {
 	if rd, err := f.Read(buf[:]); err != nil {
 		return errors.Wrap(err, 0)
 	} else if rd >= 3 && "ID3" == string(buf[:3]) {
-		id3.FirstFrameOffset, err = GetId3v2Len(f)
+		id3.FirstFrameOffset, err = GetId3v2TagLen(f, 0)
 		if err != nil {
 			return err
 		}
@@ -71,6 +72,32 @@ func SkipId3v2(f *tools.File, id3 *Mp3Entry) error {
 	}
 }
 
+// Get the embedded album art found by the metadata parsers, either from the
+// entry itself or from its position in the file. Returns nil if the entry
+// has no album art.
+func ReadAlbumArt(f *tools.File, id3 *Mp3Entry) ([]byte, error) {
+	if !id3.HasAlbumArt {
+		return nil, nil
+	}
+
+	if id3.AlbumArt.Data != nil {
+		return id3.AlbumArt.Data, nil
+	}
+
+	if _, err := f.Seek(int64(id3.AlbumArt.Pos), io.SeekStart); err != nil {
+		return nil, errors.Wrap(err, 0)
+	}
+
+	buf := make([]byte, id3.AlbumArt.Size)
+	if rd, err := f.Read(buf); err != nil {
+		return nil, errors.Wrap(err, 0)
+	} else if rd != len(buf) {
+		return nil, errors.New("failed to read album art")
+	}
+
+	return buf, nil
+}
+
 // Read an unsigned 32-bit integer from a big-endian file.
 func ReadUint32be(f *tools.File) (result uint32, read int, err error) {
 	var buf [4]byte
@@ -121,82 +148,182 @@ func GetShortLE(buf []byte) uint16 {
 	return uint16(p[0]) | (uint16(p[1]) << 8)
 }
 
+// Parse a tag of a Vorbis comment or an APE tag into id3. The name is looked
+// up in FieldTable.
 func ParseTag(name, value string, id3 *Mp3Entry, tagType TagType) (err error) {
 	var p *string = nil
 
-	if (tools.Strcasecmp(name, "track") && tagType == TagTypeApe) || (tools.Strcasecmp(name, "tracknumber") && tagType == TagTypeVorbis) {
-		id3.TrackNum, err = strconv.Atoi(value)
-		if err != nil {
-			return errors.Wrap(err, 0)
+	field, _ := CanonicalField(name, tagType)
+	switch field {
+	case FieldTrackNumber:
+		// May be given as "n/total"
+		n, total := ParseTagNumber(value)
+		if id3.TrackNum == 0 {
+			id3.TrackNum = n
+		}
+		if id3.TrackTotal == 0 {
+			id3.TrackTotal = total
 		}
 		p = &id3.TrackString
-	} else if tools.Strcasecmp(name, "discnumber") || tools.Strcasecmp(name, "disc") {
-		id3.DiscNum, err = strconv.Atoi(value)
-		if err != nil {
-			return errors.Wrap(err, 0)
+	case FieldDiscNumber:
+		n, total := ParseTagNumber(value)
+		if id3.DiscNum == 0 {
+			id3.DiscNum = n
+		}
+		if id3.DiscTotal == 0 {
+			id3.DiscTotal = total
 		}
 		p = &id3.DiscString
-	} else if (tools.Strcasecmp(name, "year") && tagType == TagTypeApe) || (tools.Strcasecmp(name, "date") && tagType == TagTypeVorbis) {
-		// Date's can be in any format in Vorbis. However most of them
-		// are in ISO8601 format so if we try and parse the first part
-		// of the tag as a number, we should get the year. If we get crap,
-		// then act like we never parsed it.
-		if len(value) >= 4 {
-			value = string([]rune(value)[:4])
-			id3.Year, err = strconv.Atoi(value)
-			if err != nil {
-				id3.Year = 0
-				return
-			} else if id3.Year < 1900 {
-				// yeah, not likely
-				id3.Year = 0
-			}
-			p = &id3.YearString
+	case FieldDate:
+		// Dates can be in any format in Vorbis. However most of them are
+		// in ISO8601 format, so we parse as much of it as we can. If we get
+		// crap, then act like we never parsed it.
+		if !SetReleaseDate(id3, value) {
+			return
 		}
-	} else {
-		switch {
-		case tools.Strcasecmp(name, "title"):
-			p = &id3.Title
-		case tools.Strcasecmp(name, "artist"):
-			p = &id3.Artist
-		case tools.Strcasecmp(name, "album"):
-			p = &id3.Album
-		case tools.Strcasecmp(name, "genre"):
-			p = &id3.Genre
-		case tools.Strcasecmp(name, "composer"):
-			p = &id3.Composer
-		case tools.Strcasecmp(name, "comment"):
-			p = &id3.Comment
-		case tools.Strcasecmp(name, "albumartist"):
-			p = &id3.AlbumArtist
-		case tools.Strcasecmp(name, "album artist"):
-			p = &id3.AlbumArtist
-		case tools.Strcasecmp(name, "ensemble"):
-			p = &id3.AlbumArtist
-		case tools.Strcasecmp(name, "grouping"):
-			p = &id3.Grouping
-		case tools.Strcasecmp(name, "content group"):
-			p = &id3.Grouping
-		case tools.Strcasecmp(name, "contentgroup"):
-			p = &id3.Grouping
-		case tools.Strcasecmp(name, "musicbrainz_trackid"):
-			p = &id3.mbTrackId
-		case tools.Strcasecmp(name, "http://musicbrainz.org"):
-			p = &id3.mbTrackId
-		default:
+		p = &id3.YearString
+	case FieldOriginalDate:
+		SetOriginalDate(id3, value)
+		return
+	case FieldRating, FieldPlayCount:
+		ParseRatingTag(name, value, id3)
+		return
+	default:
+		if p = id3.fieldString(field); p == nil && !SetField(id3, field, value) {
 			ParseReplayGain(name, value, id3)
 		}
 	}
 
 	// Do not overwrite already available metadata. Especially when reading
 	// tags with e.g. multiple genres / artists. This way only the first
-	// of multiple entries is used, all following are dropped.
-	if p != nil && *p == "" {
-		if len(value) > Id3V2MaxItemSize {
-			value = string([]rune(value)[:Id3V2MaxItemSize])
+	// of multiple entries is used for the field, all of them are kept in
+	// id3.Values.
+	if p == &id3.Lyrics.Text {
+		// Lyrics get their line endings normalized
+		AddTagValue(id3, p, NormalizeLyrics(value))
+	} else if p != nil {
+		AddTagValue(id3, p, value)
+	}
+
+	return
+}
+
+// Parse the rating and play count tags used by Vorbis comments, APE tags,
+// ID3 TXXX frames and MP4 freeform atoms. Returns true if name is one of
+// them. Ratings only replace a missing rating, play counts a lower count.
+func ParseRatingTag(name, value string, id3 *Mp3Entry) bool {
+	scale := -1
+	switch {
+	case tools.Strcasecmp(name, "rating"):
+		scale = RatingScaleAuto
+	case tools.Strcasecmp(name, "fmps_rating"):
+		scale = RatingScaleFmps
+	case tools.Strcasecmp(name, "rate"):
+		// iTunes freeform atom
+		scale = RatingScalePercent
+	case tools.Strcasecmp(name, "fmps_playcount"), tools.Strcasecmp(name, "playcount"), tools.Strcasecmp(name, "play_count"):
+		// FMPS play counts are floats as well
+		if count, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil && int64(count) > id3.PlayCount {
+			id3.PlayCount = int64(count)
 		}
+		return true
+	default:
+		return false
+	}
+
+	if rating, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil && id3.Rating == 0 {
+		id3.Rating = ScaleRating(rating, scale)
+	}
+
+	return true
+}
+
+// Scale a rating to Rockbox's scale of 0 (not rated) to RatingMax (10):
+//
+//   - RatingScaleFmps: the 0.0 to 1.0 range is multiplied by 10.
+//   - RatingScalePercent: the 0 to 100 range is divided by 10.
+//   - RatingScalePopm: the 1 to 255 range is scaled linearly, but never
+//     below 1, so the common 1, 64, 128, 196, 255 star values turn into
+//     1, 3, 5, 8, 10. 0 means unknown.
+//   - RatingScaleAuto: values below 1.0 with a fraction are taken as FMPS,
+//     whole numbers up to 10 as Rockbox's scale, and larger ones as
+//     percent.
+//
+// All results are rounded to the nearest integer and clamped to the scale.
+func ScaleRating(value float64, scale int) int {
+	if scale == RatingScaleAuto {
+		switch {
+		case value > 0 && value < 1:
+			scale = RatingScaleFmps
+		case value <= RatingMax && value == math.Trunc(value):
+			scale = -1
+		default:
+			scale = RatingScalePercent
+		}
+	}
+
+	switch scale {
+	case RatingScaleFmps:
+		value *= RatingMax
+	case RatingScalePercent:
+		value /= 100 / RatingMax
+	case RatingScalePopm:
+		value = value * RatingMax / 255
+	}
+
+	rating := int(math.Floor(value + 0.5))
+	if scale == RatingScalePopm && rating == 0 && value > 0 {
+		rating = 1
+	} else if rating < 0 {
+		rating = 0
+	} else if rating > RatingMax {
+		rating = RatingMax
+	}
+
+	return rating
+}
+
+// Get the list of all values of the field p points to, nil if the field
+// can only hold a single value.
+func (id3 *Mp3Entry) valuesOf(p *string) *[]string {
+	switch p {
+	case &id3.Title:
+		return &id3.Values.Title
+	case &id3.Artist:
+		return &id3.Values.Artist
+	case &id3.Album:
+		return &id3.Values.Album
+	case &id3.AlbumArtist:
+		return &id3.Values.AlbumArtist
+	case &id3.Composer:
+		return &id3.Values.Composer
+	case &id3.Genre:
+		return &id3.Values.Genre
+	case &id3.Grouping:
+		return &id3.Values.Grouping
+	}
+
+	return nil
+}
+
+// Add a value to the field of id3 that p points to. The field only takes the
+// value if it is still empty, multi-valued fields also add it to the list of
+// all their values.
+func AddTagValue(id3 *Mp3Entry, p *string, value string) {
+	if value == "" {
+		return
+	}
+
+	if *p == "" {
 		*p = value
 	}
 
-	return
+	if values := id3.valuesOf(p); values != nil {
+		for _, v := range *values {
+			if v == value {
+				return
+			}
+		}
+		*values = append(*values, value)
+	}
 }
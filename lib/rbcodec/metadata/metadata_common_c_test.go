@@ -0,0 +1,45 @@
+package metadata
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestScaleRating(t *testing.T) {
+	tests := []struct {
+		value float64
+		scale int
+		want  int
+	}{
+		{0.5, RatingScaleFmps, 5},
+		{1.0, RatingScaleFmps, 10},
+		{0.04, RatingScaleFmps, 0},
+		{0.05, RatingScaleFmps, 1},
+		{80, RatingScalePercent, 8},
+		{100, RatingScalePercent, 10},
+		{150, RatingScalePercent, 10},
+		{-20, RatingScalePercent, 0},
+		{0, RatingScalePopm, 0},
+		{1, RatingScalePopm, 1},
+		{64, RatingScalePopm, 3},
+		{128, RatingScalePopm, 5},
+		{196, RatingScalePopm, 8},
+		{255, RatingScalePopm, 10},
+		{0.7, RatingScaleAuto, 7},
+		{0, RatingScaleAuto, 0},
+		{3, RatingScaleAuto, 3},
+		{8, RatingScaleAuto, 8},
+		{10, RatingScaleAuto, 10},
+		{2.5, RatingScaleAuto, 0},
+		{60, RatingScaleAuto, 6},
+		{100, RatingScaleAuto, 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%g/%d", tt.value, tt.scale), func(t *testing.T) {
+			if got := ScaleRating(tt.value, tt.scale); got != tt.want {
+				t.Errorf("ScaleRating(%g, %d) = %d, want %d", tt.value, tt.scale, got, tt.want)
+			}
+		})
+	}
+}
@@ -5,9 +5,14 @@ const (
 	TagValueLength = 128
 )
 
+// Tag formats, see FieldNames for how they name the fields
 const (
 	TagTypeApe = TagType(iota + 1)
 	TagTypeVorbis
+	TagTypeId3v22
+	TagTypeId3v23
+	TagTypeId3v24
+	TagTypeMp4
 )
 
 type TagType int
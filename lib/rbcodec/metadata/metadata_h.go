@@ -8,6 +8,26 @@ import (
 const (
 	Id3V2MaxItemSize = 240
 	Id3V2BufSize     = 300
+	// Maximum number of tags followed through SEEK frames
+	Id3V2MaxChainedTags = 16
+	// Maximum size of a decompressed frame, unless the frame declares its
+	// decompressed size
+	Id3V2MaxDecompressedSize = 16 * 1024 * 1024
+)
+
+// ID3v2 frame format flags
+const (
+	// ID3v2.3
+	Id3v23FrameCompression = 0x0080
+	Id3v23FrameEncryption  = 0x0040
+	Id3v23FrameGrouping    = 0x0020
+
+	// ID3v2.4
+	Id3v24FrameGrouping    = 0x0040
+	Id3v24FrameCompression = 0x0008
+	Id3v24FrameEncryption  = 0x0004
+	Id3v24FrameUnsync      = 0x0002
+	Id3v24FrameDataLength  = 0x0001
 )
 
 const (
@@ -130,6 +150,14 @@ const (
 	AfmtOpus
 	// AAC bitstream format
 	AfmtAacBsf
+	// Standard MIDI File (SMF and RIFF RMID)
+	AfmtMidi
+	// Adaptive Multi-Rate narrowband speech
+	AfmtAmrNb
+	// Adaptive Multi-Rate wideband speech
+	AfmtAmrWb
+	// Portable Sound Format family (PSF, PSF2, 2SF, GSF, USF, ...)
+	AfmtPsf
 
 	// add new formats at any index above this line to have a sensible order -
 	// specified array index inits are used
@@ -184,6 +212,9 @@ type Mp3AlbumArt struct {
 	TypeAA Mp3AAType
 	Size   int
 	Pos    int
+	// Image data, for images that don't appear in the file as is, like
+	// the ones in unsynchronised ID3v2 tags. Pos is -1 in that case.
+	Data []byte
 }
 
 type EmbeddedCueSheet struct {
@@ -192,6 +223,250 @@ type EmbeddedCueSheet struct {
 	Encoding CharacterEncoding
 }
 
+type MidiInfo struct {
+	// SMF format (0, 1 or 2)
+	Format int
+	// Number of MTrk chunks
+	Tracks int
+	// Ticks per quarter note, 0 if the file uses SMPTE timing
+	PPQN int
+	// First time signature found, 0/0 if there is none
+	TimeSigNumerator   int
+	TimeSigDenominator int
+	// Copyright notice meta event
+	Copyright string
+	// Lyric meta events, or the karaoke text events of a .kar file
+	Lyrics string
+	// True if the file follows the .kar karaoke conventions
+	Karaoke bool
+}
+
+type PsfInfo struct {
+	// Version byte of the header, identifies the platform
+	Version byte
+	// Name of the platform the file was ripped from
+	Platform string
+	// Song length and fade out time in ms, as given by the tag
+	SongLength uint64
+	Fade       uint64
+	// Libraries this file depends on, _lib first, then _lib2, _lib3, ...
+	Libs []string
+	// Person who created the rip (psfby, gsfby, ...)
+	RippedBy  string
+	Copyright string
+	// True if the tag declares itself as UTF-8
+	Utf8 bool
+}
+
+type Lyrics3Tag struct {
+	// 1 or 2, 0 if there is no Lyrics3 tag
+	Version int
+	// Lyrics (LYR field for version 2)
+	Lyrics string
+	// All version 2 fields, by their three character id
+	Fields map[string]string
+}
+
+// Time stamp formats of synchronised lyrics, as used by ID3 SYLT frames
+const (
+	// Time stamps count MPEG frames
+	LyricsTimeMpegFrames = 1
+	// Time stamps are in ms
+	LyricsTimeMs = 2
+)
+
+// A single line (or syllable) of synchronised lyrics
+type SyncedLyric struct {
+	// Start of the line, in the unit given by Mp3Lyrics.TimeFormat
+	Time uint64
+	Text string
+}
+
+type Mp3Lyrics struct {
+	// Unsynchronised lyrics
+	Text string
+	// ISO-639-2 language code and content description, if the tag has them
+	Language    string
+	Description string
+
+	// Synchronised lyrics, ordered by time
+	Synced []SyncedLyric
+	// LyricsTimeMpegFrames or LyricsTimeMs
+	TimeFormat byte
+	// Language and content description of the synchronised lyrics
+	SyncedLanguage    string
+	SyncedDescription string
+}
+
+// A chapter of a podcast or audiobook, from an ID3v2 CHAP frame
+type Mp3Chapter struct {
+	// Element id, unique within the tag
+	Id string
+	// Start and end time in ms
+	Start uint64
+	End   uint64
+	// Byte offsets of the first frame of the chapter and of the frame
+	// following it, -1 if the tag doesn't give them
+	StartOffset int64
+	EndOffset   int64
+	Title       string
+	Subtitle    string
+	Url         string
+	HasImage    bool
+	Image       Mp3AlbumArt
+}
+
+// A table of contents, from an ID3v2 CTOC frame
+type Mp3ChapterToc struct {
+	// Element id, unique within the tag
+	Id string
+	// This is the root of the tree of tables of contents
+	TopLevel bool
+	// The children are listed in playback order
+	Ordered bool
+	// Element ids of the chapters and nested tables of contents
+	Children []string
+	Title    string
+}
+
+// Rating scales of the tag formats, see ScaleRating
+const (
+	// A value of Rockbox's 0 to 10 scale, a percent value or a fraction,
+	// guessed from the value
+	RatingScaleAuto = iota
+	// 0.0 to 1.0, as used by FMPS_RATING
+	RatingScaleFmps
+	// 0 to 100, as used by iTunes
+	RatingScalePercent
+	// 0 to 255, as used by ID3 POPM frames
+	RatingScalePopm
+	// Highest rating of Rockbox's scale, 0 means not rated
+	RatingMax = 10
+)
+
+// Rating and play counter of an ID3 POPM frame
+type Popularimeter struct {
+	// The user the rating belongs to
+	Email string
+	// 1 (worst) to 255 (best), 0 if unknown
+	Rating byte
+	// Play counter, 0 if the frame doesn't have one
+	Counter uint64
+}
+
+// MusicBrainz identifiers of a track. All of them are UUIDs in lower case,
+// except for the disc id.
+type MusicBrainzIds struct {
+	// The recording, and the track on the release
+	TrackId        string
+	ReleaseTrackId string
+	// The release and its release group
+	AlbumId        string
+	ReleaseGroupId string
+	// Every artist of a collaboration has an id of its own
+	ArtistIds      []string
+	AlbumArtistIds []string
+	WorkId         string
+	// The disc id of the CD the track was ripped from
+	DiscId string
+}
+
+// A person credited for a role, e.g. the producer. Musicians have the role
+// "performer" and the instrument they play, which may be empty.
+type Credit struct {
+	Role       string
+	Name       string
+	Instrument string
+}
+
+// Kinds of media, as numbered by the MP4 stik atom of iTunes
+const (
+	MediaKindUnknown    = 0
+	MediaKindMusic      = 1
+	MediaKindAudiobook  = 2
+	MediaKindMusicVideo = 6
+	MediaKindMovie      = 9
+	MediaKindTvShow     = 10
+	MediaKindBooklet    = 11
+	MediaKindRingtone   = 14
+	MediaKindPodcast    = 21
+	MediaKindITunesU    = 23
+)
+
+// Podcast and audiobook metadata
+type Mp3Podcast struct {
+	// One of the MediaKind constants. Podcast episodes and M4B files are
+	// recognised as well when there is no MP4 stik atom.
+	MediaKind int
+	// The file is a podcast episode
+	IsPodcast bool
+	// URL of the podcast feed, and the GUID of the episode in the feed
+	FeedUrl     string
+	EpisodeGuid string
+	// Short and long description of the episode or book
+	Description     string
+	LongDescription string
+	Category        string
+	Keywords        []string
+	// The name of the TV show or podcast
+	Show string
+	// The reader of an audiobook
+	Narrator string
+}
+
+// A comment of a tag. Only ID3 comments have a language, an ISO-639-2 code
+// like "eng", and a short description that tells comments apart.
+type Comment struct {
+	Language    string
+	Description string
+	Text        string
+}
+
+// iTunes technical metadata, kept in ID3 comments and MP4 freeform atoms
+type Mp3ITunes struct {
+	// Sound Check volume normalization (iTunNORM)
+	Normalization []uint32
+	// Gapless playback information (iTunSMPB): the encoder delay and the
+	// padding in samples, and the number of samples without them. LeadTrim
+	// and TailTrim are set from it as well.
+	EncoderDelay   int
+	EncoderPadding int
+	SampleCount    int64
+	// Part of a gapless album (iTunPGAP)
+	Gapless bool
+}
+
+// Precision of a TagDate, each one adds a part to the one before
+const (
+	DatePrecisionNone = iota
+	DatePrecisionYear
+	DatePrecisionMonth
+	DatePrecisionDay
+	DatePrecisionHour
+	DatePrecisionMinute
+	DatePrecisionSecond
+)
+
+// A date from a tag. Only the parts up to Precision are set.
+type TagDate struct {
+	Year, Month, Day     int
+	Hour, Minute, Second int
+	Precision            int
+}
+
+// All values of the fields that can hold several values, like the artists
+// of a collaboration. The matching string fields of Mp3Entry hold the first
+// value.
+type Mp3Values struct {
+	Title       []string
+	Artist      []string
+	Album       []string
+	AlbumArtist []string
+	Composer    []string
+	Genre       []string
+	Grouping    []string
+}
+
 type Mp3Entry struct {
 	Path        string
 	Title       string
@@ -205,8 +480,11 @@ type Mp3Entry struct {
 	Comment     string
 	AlbumArtist string
 	Grouping    string
+	Values      Mp3Values
 	DiscNum     int
+	DiscTotal   int
 	TrackNum    int
+	TrackTotal  int
 	Layer       int
 	Year        int
 	Id3Version  Id3Version
@@ -215,6 +493,65 @@ type Mp3Entry struct {
 	Frequency   uint64
 	Id3v2len    uint64
 	Id3v1len    uint64
+	Lyrics3len  uint64
+	ApeLen      uint64
+
+	// The names to sort by, e.g. "Beatles, The", if the tags have them
+	ArtistSort      string
+	AlbumSort       string
+	TitleSort       string
+	AlbumArtistSort string
+	ComposerSort    string
+
+	// Beats per minute, 0 if unknown
+	Bpm int
+	// International Standard Recording Code
+	Isrc string
+	// Record label or publisher
+	Label     string
+	Copyright string
+	// The person or organisation that encoded the file, and the encoder and
+	// its settings
+	EncodedBy       string
+	EncoderSettings string
+	// Part of a compilation of various artists
+	Compilation bool
+	Mood        string
+	// Initial musical key, e.g. "Ebm"
+	Key string
+	// Media the audio was taken from, e.g. "CD" or "Vinyl"
+	Media         string
+	Barcode       string
+	CatalogNumber string
+
+	// Classical music: the work, the movement of it, and the performers
+	Work           string
+	MovementName   string
+	MovementNumber int
+	MovementTotal  int
+	Conductor      string
+	// Orchestra, choir or band
+	Ensemble string
+
+	// The people involved in the recording, in the order of the tags
+	Credits []Credit
+
+	// Podcast and audiobook metadata
+	Podcast Mp3Podcast
+
+	// All comments of the tags, Comment is the one to show, see
+	// ChooseComment
+	Comments []Comment
+	ITunes   Mp3ITunes
+
+	// How to merge the fields of several tag blocks, set it before reading
+	// the metadata. FieldSources tells the block each field was taken from.
+	MergePolicy  MergePolicy
+	FieldSources map[Field]TagBlock
+
+	// Length of ID3v2 tags appended to the file or chained through SEEK
+	// frames
+	Id3v2AppendedLen uint64
 
 	// Byte offset to first real MP3 frame.
 	// Used for skipping leading garbage to
@@ -242,6 +579,8 @@ type Mp3Entry struct {
 	// MP3 stream specific info
 	// number of frames in the file (if VBR)
 	FrameCount uint64
+	// number of samples per frame
+	FrameSamples uint64
 
 	// Used for A52/AC3
 	// number of bytes per frame (if CBR)
@@ -260,6 +599,27 @@ type Mp3Entry struct {
 	// Added for AAC HE SBR
 	NeedsUpsamplingCorrection bool
 
+	// Added for MIDI
+	Midi MidiInfo
+
+	// Added for the PSF family
+	Psf PsfInfo
+
+	// Lyrics3 block between the audio data and the ID3v1 tag
+	Lyrics3 Lyrics3Tag
+
+	// Lyrics, from whichever tag holds them
+	Lyrics Mp3Lyrics
+
+	// Date of the release, and of the original release of reissues, as
+	// precise as the tags give them
+	ReleaseDate  TagDate
+	OriginalDate TagDate
+
+	// Chapters, in playback order, and the tables of contents listing them
+	Chapters    []Mp3Chapter
+	ChapterTocs []Mp3ChapterToc
+
 	// resume related
 	Offset uint64
 	Index  int
@@ -270,6 +630,9 @@ type Mp3Entry struct {
 	PlayCount  int64
 	LastPlayed int64
 
+	// All ID3 POPM frames, Rating and PlayCount are taken from them
+	Popularimeters []Popularimeter
+
 	// replaygain support
 	// holds the level in dB * (1<<FP_BITS)
 	TrackLevel int64
@@ -289,6 +652,9 @@ type Mp3Entry struct {
 	EmbeddedCuesheet    EmbeddedCueSheet
 	Cuesheet            apps.Cuesheet
 
-	// Musicbrainz Track ID
-	mbTrackId string
+	// Musicbrainz identifiers
+	MusicBrainz MusicBrainzIds
+
+	// Offset of the next ID3v2 tag, from the SEEK frame of the last one read
+	id3v2Seek int64
 }
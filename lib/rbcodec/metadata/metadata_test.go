@@ -0,0 +1,26 @@
+package metadata
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"rbmetadata-go/tools"
+	"testing"
+)
+
+// Write data to a file called name in a temporary directory and open it
+func openTestFile(t *testing.T, name string, data []byte) *tools.File {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fd, err := tools.Open(path, true, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = fd.Close() })
+
+	return fd
+}
@@ -0,0 +1,417 @@
+package metadata
+
+import (
+	"github.com/go-errors/errors"
+	"io"
+	"rbmetadata-go/firmware/common"
+	"rbmetadata-go/tools"
+	"sort"
+	"strings"
+)
+
+const (
+	// Default tempo in microseconds per quarter note (120 bpm)
+	MidiDefaultTempo = 500000
+
+	MidiMetaText      = 0x01
+	MidiMetaCopyright = 0x02
+	MidiMetaTrackName = 0x03
+	MidiMetaLyric     = 0x05
+	MidiMetaEndTrack  = 0x2F
+	MidiMetaTempo     = 0x51
+	MidiMetaTimeSig   = 0x58
+)
+
+type midiTempo struct {
+	Tick  uint64
+	Tempo uint64
+}
+
+// State shared by all tracks of a file while they are walked
+type midiState struct {
+	Tempos   []midiTempo
+	EndTick  uint64
+	Lyrics   strings.Builder
+	KarTitle int
+}
+
+func GetMidiMetadata(fd *tools.File, id3 *Mp3Entry) error {
+	var buf [14]byte
+
+	if _, err := fd.Seek(0, io.SeekStart); err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	if rd, err := fd.Read(buf[:12]); err != nil {
+		return errors.Wrap(err, 0)
+	} else if rd < 12 {
+		return errors.New("failed to read midi header")
+	}
+
+	// RIFF wrapped files keep the SMF data in the "data" chunk
+	offset := int64(0)
+	if string(buf[:4]) == "RIFF" && string(buf[8:12]) == "RMID" {
+		var err error
+		if offset, err = findRiffChunk(fd, 12, "data"); err != nil {
+			return err
+		}
+	}
+
+	if _, err := fd.Seek(offset, io.SeekStart); err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	if rd, err := fd.Read(buf[:]); err != nil {
+		return errors.Wrap(err, 0)
+	} else if rd < len(buf) {
+		return errors.New("failed to read midi header")
+	} else if string(buf[:4]) != "MThd" {
+		return errors.New("not a midi file")
+	}
+
+	headerLen := GetLongBE(buf[4:])
+	if headerLen < 6 {
+		return errors.Errorf("midi header too short: %d", headerLen)
+	}
+
+	format := int(uint16(buf[8])<<8 | uint16(buf[9]))
+	tracks := int(uint16(buf[10])<<8 | uint16(buf[11]))
+	division := uint16(buf[12])<<8 | uint16(buf[13])
+
+	if format > 2 {
+		return errors.Errorf("unsupported midi format %d", format)
+	}
+
+	if _, err := fd.Seek(int64(headerLen)-6, io.SeekCurrent); err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	id3.Midi = MidiInfo{
+		Format:  format,
+		Karaoke: tools.Strcasecmp(fileExtension(fd.Name()), "kar"),
+	}
+	if division&0x8000 == 0 {
+		id3.Midi.PPQN = int(division)
+	}
+
+	id3.Length = 0
+
+	var state midiState
+	found := 0
+	for found < tracks {
+		if rd, err := fd.Read(buf[:8]); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return errors.Wrap(err, 0)
+		} else if rd < 8 {
+			break
+		}
+
+		chunkLen := GetLongBE(buf[4:])
+
+		// Alien chunks have to be skipped
+		if string(buf[:4]) != "MTrk" {
+			if _, err := fd.Seek(int64(chunkLen), io.SeekCurrent); err != nil {
+				return errors.Wrap(err, 0)
+			}
+			continue
+		}
+
+		// A corrupt length must not make us allocate more than the file
+		// has left
+		pos, err := fd.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return errors.Wrap(err, 0)
+		}
+		if left := int64(fd.FileSize()) - pos; int64(chunkLen) > left {
+			if left <= 0 {
+				break
+			}
+			chunkLen = uint32(left)
+		}
+
+		data := make([]byte, chunkLen)
+		if rd, err := fd.Read(data); err != nil {
+			return errors.Wrap(err, 0)
+		} else if rd < len(data) {
+			// Truncated file, use what we got
+			data = data[:rd]
+		}
+
+		if format == 2 {
+			// Every track is an independent sequence with its own tempo
+			// map, so they are played back one after another.
+			state.Tempos = nil
+			state.EndTick = 0
+		}
+
+		if err := parseMidiTrack(data, found, id3, &state); err != nil {
+			return err
+		}
+
+		if format == 2 {
+			id3.Length += midiTicksToMs(state.EndTick, state.Tempos, division)
+		}
+
+		found++
+	}
+
+	if format != 2 {
+		id3.Length = midiTicksToMs(state.EndTick, state.Tempos, division)
+	}
+
+	id3.Midi.Tracks = found
+	id3.Midi.Lyrics = strings.TrimSpace(state.Lyrics.String())
+	id3.Lyrics.Text = id3.Midi.Lyrics
+	id3.Copyright = id3.Midi.Copyright
+
+	id3.VBR = false
+	id3.Filesize = fd.FileSize()
+
+	// The MIDI codec synthesizes at 44.1 kHz
+	id3.Frequency = 44100
+
+	if id3.Length == 0 {
+		return errors.New("midi length invalid")
+	}
+
+	return nil
+}
+
+// Walk all events of a single MTrk chunk, collecting meta information into
+// id3 and the tempo map and track end into state.
+func parseMidiTrack(data []byte, track int, id3 *Mp3Entry, state *midiState) error {
+	var tick uint64
+	var status byte
+
+	for len(data) > 0 {
+		delta, n := readMidiVarLen(data)
+		if n == 0 {
+			return errors.New("malformed midi delta time")
+		}
+		data = data[n:]
+		tick += uint64(delta)
+
+		if len(data) == 0 {
+			break
+		}
+
+		if data[0]&0x80 != 0 {
+			status = data[0]
+			data = data[1:]
+		} else if status == 0 {
+			return errors.New("midi running status without a status byte")
+		}
+
+		switch {
+		case status == 0xFF:
+			if len(data) < 1 {
+				return errors.New("truncated midi meta event")
+			}
+			typ := data[0]
+			ln, n := readMidiVarLen(data[1:])
+			if n == 0 || len(data) < 1+n+int(ln) {
+				return errors.New("truncated midi meta event")
+			}
+			value := data[1+n : 1+n+int(ln)]
+			data = data[1+n+int(ln):]
+
+			// Meta events cancel running status
+			status = 0
+
+			if typ == MidiMetaEndTrack {
+				data = nil
+				break
+			}
+
+			parseMidiMeta(typ, value, tick, track, id3, state)
+		case status == 0xF0 || status == 0xF7:
+			// SysEx, skip it
+			ln, n := readMidiVarLen(data)
+			if n == 0 || len(data) < n+int(ln) {
+				return errors.New("truncated midi sysex event")
+			}
+			data = data[n+int(ln):]
+			status = 0
+		default:
+			dataLen := 2
+			switch status & 0xF0 {
+			case 0xC0, 0xD0:
+				dataLen = 1
+			case 0xF0:
+				// System common messages shouldn't show up in files,
+				// but skip them properly if they do
+				switch status {
+				case 0xF1, 0xF3:
+					dataLen = 1
+				case 0xF2:
+					dataLen = 2
+				default:
+					dataLen = 0
+				}
+			}
+
+			if len(data) < dataLen {
+				return errors.New("truncated midi channel event")
+			}
+			data = data[dataLen:]
+		}
+	}
+
+	if tick > state.EndTick {
+		state.EndTick = tick
+	}
+
+	return nil
+}
+
+func parseMidiMeta(typ byte, value []byte, tick uint64, track int, id3 *Mp3Entry, state *midiState) {
+	switch typ {
+	case MidiMetaTempo:
+		if len(value) >= 3 {
+			tempo := uint64(value[0])<<16 | uint64(value[1])<<8 | uint64(value[2])
+			state.Tempos = append(state.Tempos, midiTempo{Tick: tick, Tempo: tempo})
+		}
+	case MidiMetaTimeSig:
+		if len(value) >= 2 && id3.Midi.TimeSigDenominator == 0 {
+			id3.Midi.TimeSigNumerator = int(value[0])
+			id3.Midi.TimeSigDenominator = 1 << value[1]
+		}
+	case MidiMetaCopyright:
+		if id3.Midi.Copyright == "" {
+			id3.Midi.Copyright = strings.TrimSpace(common.IsoDecode(value, -1))
+		}
+	case MidiMetaTrackName:
+		// The name of the first track is the sequence name
+		if track == 0 && id3.Title == "" {
+			id3.Title = strings.TrimSpace(common.IsoDecode(value, -1))
+		}
+	case MidiMetaLyric:
+		state.Lyrics.WriteString(common.IsoDecode(value, -1))
+	case MidiMetaText:
+		text := common.IsoDecode(value, -1)
+
+		if strings.HasPrefix(text, "@") {
+			// Karaoke header: @K file type, @T title lines, @I info,
+			// @L language, @V version
+			if len(text) < 2 {
+				return
+			}
+
+			switch text[1] {
+			case 'K':
+				id3.Midi.Karaoke = true
+			case 'T':
+				// The first title line is the song title, the second one
+				// the artist.
+				value := strings.TrimSpace(text[2:])
+				switch state.KarTitle {
+				case 0:
+					id3.Title = value
+				case 1:
+					id3.Artist = value
+				}
+				state.KarTitle++
+			case 'I':
+				if id3.Comment == "" {
+					id3.Comment = strings.TrimSpace(text[2:])
+				}
+			}
+		} else if id3.Midi.Karaoke {
+			// Karaoke lyrics are syllables in text events, where "\" starts
+			// a new paragraph and "/" a new line.
+			switch {
+			case strings.HasPrefix(text, "\\"):
+				state.Lyrics.WriteString("\n\n")
+				text = text[1:]
+			case strings.HasPrefix(text, "/"):
+				state.Lyrics.WriteString("\n")
+				text = text[1:]
+			}
+			state.Lyrics.WriteString(text)
+		} else if id3.Comment == "" {
+			id3.Comment = strings.TrimSpace(text)
+		}
+	}
+}
+
+// Convert a tick count to milliseconds, using the given tempo map.
+func midiTicksToMs(ticks uint64, tempos []midiTempo, division uint16) uint64 {
+	if division&0x8000 != 0 {
+		// SMPTE timing: frames per second and ticks per frame
+		fps := uint64(-int8(division >> 8))
+		tpf := uint64(division & 0xFF)
+		if fps == 0 || tpf == 0 {
+			return 0
+		}
+		if fps == 29 {
+			// 29.97 drop frame
+			return ticks * 1000 * 100 / (2997 * tpf)
+		}
+		return ticks * 1000 / (fps * tpf)
+	}
+
+	if division == 0 {
+		return 0
+	}
+
+	tempos = append([]midiTempo(nil), tempos...)
+	sort.SliceStable(tempos, func(i, j int) bool {
+		return tempos[i].Tick < tempos[j].Tick
+	})
+
+	// Accumulate microseconds * PPQN to stay exact until the very end
+	var total uint64
+	lastTick, tempo := uint64(0), uint64(MidiDefaultTempo)
+	for _, t := range tempos {
+		if t.Tick >= ticks {
+			break
+		}
+		total += (t.Tick - lastTick) * tempo
+		lastTick, tempo = t.Tick, t.Tempo
+	}
+	total += (ticks - lastTick) * tempo
+
+	return total / uint64(division) / 1000
+}
+
+// Read a MIDI variable length quantity. Returns the value and the number of
+// bytes used, 0 if the quantity is malformed.
+func readMidiVarLen(data []byte) (value uint32, n int) {
+	for n < len(data) && n < 4 {
+		c := data[n]
+		n++
+		value = value<<7 | uint32(c&0x7F)
+		if c&0x80 == 0 {
+			return value, n
+		}
+	}
+	return 0, 0
+}
+
+// Find the chunk with the given id in a RIFF file, starting at offset.
+// Returns the offset of the chunk data.
+func findRiffChunk(fd *tools.File, offset int64, id string) (int64, error) {
+	var buf [8]byte
+
+	for {
+		if _, err := fd.Seek(offset, io.SeekStart); err != nil {
+			return 0, errors.Wrap(err, 0)
+		}
+
+		if rd, err := fd.Read(buf[:]); err != nil {
+			return 0, errors.Wrap(err, 0)
+		} else if rd < len(buf) {
+			return 0, errors.Errorf("riff chunk '%s' not found", id)
+		}
+
+		size := int64(GetLongLE(buf[4:]))
+		if string(buf[:4]) == id {
+			return offset + 8, nil
+		}
+
+		// Chunks are padded to an even size
+		offset += 8 + size + size&1
+	}
+}
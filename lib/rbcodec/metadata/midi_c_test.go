@@ -0,0 +1,93 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestMidiTicksToMs(t *testing.T) {
+	tests := []struct {
+		name     string
+		ticks    uint64
+		tempos   []midiTempo
+		division uint16
+		want     uint64
+	}{
+		{"default tempo", 960, nil, 480, 1000},
+		{"one tempo", 960, []midiTempo{{0, 1000000}}, 480, 2000},
+		{"tempo change", 960, []midiTempo{{0, 500000}, {480, 1000000}}, 480, 1500},
+		{"unsorted tempos", 960, []midiTempo{{480, 1000000}, {0, 500000}}, 480, 1500},
+		{"tempo after the end", 480, []midiTempo{{960, 1000000}}, 480, 500},
+		{"no division", 960, nil, 0, 0},
+		{"smpte 25 fps", 1000, nil, uint16(0xE7)<<8 | 40, 1000},
+		{"smpte 29.97 fps", 2997, nil, uint16(0xE3)<<8 | 1, 100000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := midiTicksToMs(tt.ticks, tt.tempos, tt.division); got != tt.want {
+				t.Errorf("midiTicksToMs() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMidiTicksToMsKeepsTempoOrder(t *testing.T) {
+	tempos := []midiTempo{{480, 1000000}, {0, 500000}}
+	midiTicksToMs(960, tempos, 480)
+
+	if tempos[0].Tick != 480 || tempos[1].Tick != 0 {
+		t.Errorf("tempo map reordered: %v", tempos)
+	}
+}
+
+// Build a MIDI file from its header values and track event data
+func midiFile(format, tracks, division uint16, events ...[]byte) []byte {
+	var b bytes.Buffer
+	b.WriteString("MThd")
+	_ = binary.Write(&b, binary.BigEndian, []uint32{6})
+	_ = binary.Write(&b, binary.BigEndian, []uint16{format, tracks, division})
+	for _, ev := range events {
+		b.WriteString("MTrk")
+		_ = binary.Write(&b, binary.BigEndian, uint32(len(ev)))
+		b.Write(ev)
+	}
+	return b.Bytes()
+}
+
+func TestGetMidiMetadataLength(t *testing.T) {
+	endTrack := []byte{0x00, 0xFF, 0x2F, 0x00}
+	// Tempo of 1 s per quarter note, a note lasting 960 ticks
+	slow := append([]byte{0x00, 0xFF, 0x51, 0x03, 0x0F, 0x42, 0x40,
+		0x00, 0x90, 0x3C, 0x40, 0x87, 0x40, 0x80, 0x3C, 0x40}, endTrack...)
+	// A note lasting 480 ticks at the default tempo
+	short := append([]byte{0x00, 0x90, 0x3C, 0x40, 0x83, 0x60, 0x80, 0x3C, 0x40}, endTrack...)
+
+	tests := []struct {
+		name       string
+		data       []byte
+		wantLength uint64
+		wantTracks int
+	}{
+		{"format 0", midiFile(0, 1, 480, slow), 2000, 1},
+		{"format 1 shares the tempo map", midiFile(1, 2, 480, slow, short), 2000, 2},
+		{"format 2 plays tracks in turn", midiFile(2, 2, 480, slow, short), 2500, 2},
+		{"missing tracks", midiFile(1, 3, 480, slow), 2000, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var id3 Mp3Entry
+			if err := GetMidiMetadata(openTestFile(t, "test.mid", tt.data), &id3); err != nil {
+				t.Fatal(err)
+			}
+			if id3.Length != tt.wantLength {
+				t.Errorf("Length = %d, want %d", id3.Length, tt.wantLength)
+			}
+			if id3.Midi.Tracks != tt.wantTracks {
+				t.Errorf("Midi.Tracks = %d, want %d", id3.Midi.Tracks, tt.wantTracks)
+			}
+		})
+	}
+}
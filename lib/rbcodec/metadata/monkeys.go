@@ -69,5 +69,9 @@ func GetMonkeysMetadata(fd *tools.File, id3 *Mp3Entry) error {
 	id3.Length = (uint64(totalSamples) * 1000) / id3.Frequency
 	id3.Bitrate = int((id3.Filesize * 8) / id3.Length)
 
-	return ReadApeTags(fd, id3)
+	return ReadTagBlocks(id3, []TagBlockReader{
+		{TagBlockApe, func() error {
+			return ReadApeTags(fd, id3)
+		}},
+	})
 }
\ No newline at end of file
@@ -12,7 +12,7 @@ import (
 //
 // Note, that this returns true for successful, false for error!
 func GetMp3Metadata(fd *tools.File, id3 *Mp3Entry) (err error) {
-	id3.Title = ""
+	clearTagFields(id3)
 
 	id3.Filesize = fd.FileSize()
 
@@ -22,32 +22,42 @@ func GetMp3Metadata(fd *tools.File, id3 *Mp3Entry) (err error) {
 		id3.Id3v1len = uint64(ln)
 	}
 
-	if ln, err := GetId3v2Len(fd); err != nil {
+	if ln, err := GetId3v2TagLen(fd, 0); err != nil {
 		return err
 	} else {
 		id3.Id3v2len = uint64(ln)
 	}
 
-	id3.TrackNum = 0
-	id3.TrackString = ""
-	id3.DiscNum = 0
-	id3.DiscString = ""
-
-	if id3.Id3v2len != 0 {
-		if err = SetId3v2Title(fd, id3); err != nil {
-			return
-		}
+	// The Lyrics3 tag has to be found before the appended ID3v2 tag in
+	// front of it
+	if err = ReadLyrics3Tag(fd, id3); err != nil {
+		return
 	}
 
-	if id3.Length, err = GetSongLength(fd, id3); err != nil {
+	err = ReadTagBlocks(id3, []TagBlockReader{
+		{TagBlockId3v2, func() error {
+			return ReadId3v2Tags(fd, id3)
+		}},
+		{TagBlockApe, func() error {
+			return ReadApeTags(fd, id3)
+		}},
+		{TagBlockLyrics3, func() error {
+			SetLyrics3Fields(id3)
+			return nil
+		}},
+		{TagBlockId3v1, func() error {
+			if id3.Id3v1len == 0 {
+				return nil
+			}
+			return SetId3v1Title(fd, id3)
+		}},
+	})
+	if err != nil {
 		return
 	}
 
-	// only seek to end of file if no id3v2 tags were found
-	if id3.Id3v2len == 0 {
-		if err = SetId3v1Title(fd, id3); err != nil {
-			return
-		}
+	if id3.Length, err = GetSongLength(fd, id3); err != nil {
+		return
 	}
 
 	if id3.Length == 0 || id3.Filesize < 8 {
@@ -84,15 +94,16 @@ func GetSongLength(fd *tools.File, id3 *Mp3Entry) (uint64, error) {
 
 	// Subtract the meta information from the file size to get
 	// the true size of the MP3 stream
-	id3.Filesize -= id3.Id3v1len + id3.Id3v2len
+	id3.Filesize -= id3.Id3v1len + id3.Id3v2len + id3.Lyrics3len + id3.ApeLen + id3.Id3v2AppendedLen
 
 	// Validate byte count, in case the file has been edited without
 	// updating the header.
 	if info.ByteCount != 0 {
-		expected := id3.Filesize - id3.Id3v1len - id3.Id3v2len
+		// The tags are subtracted already
+		expected := id3.Filesize
 		diff := uint64(math.Max(10240, float64(info.ByteCount/20)))
 
-		if info.ByteCount > expected+diff || info.ByteCount < expected-diff {
+		if info.ByteCount > expected+diff || info.ByteCount+diff < expected {
 			info.ByteCount = 0
 			info.FrameCount = 0
 			info.FileTime = 0
@@ -138,6 +149,7 @@ func GetSongLength(fd *tools.File, id3 *Mp3Entry) (uint64, error) {
 	}
 
 	id3.FrameCount = info.FrameCount
+	id3.FrameSamples = uint64(info.FrameSamples)
 
 	id3.VBR = info.IsVBR
 	id3.HasTOC = info.HasTOC
@@ -7,8 +7,6 @@ import (
 	"math"
 	"rbmetadata-go/firmware/include"
 	"rbmetadata-go/tools"
-	"strconv"
-	"unicode"
 	"unsafe"
 )
 
@@ -20,11 +18,17 @@ var (
 	Mp4cART = FourCC(0xa9, 'A', 'R', 'T')
 	Mp4cgrp = FourCC(0xa9, 'g', 'r', 'p')
 	Mp4cgen = FourCC(0xa9, 'g', 'e', 'n')
+	Mp4clyr = FourCC(0xa9, 'l', 'y', 'r')
+	Mp4cpil = FourCC('c', 'p', 'i', 'l')
+	Mp4pcst = FourCC('p', 'c', 's', 't')
+	Mp4stik = FourCC('s', 't', 'i', 'k')
 	Mp4chpl = FourCC('c', 'h', 'p', 'l')
 	Mp4cnam = FourCC(0xa9, 'n', 'a', 'm')
 	Mp4cwrt = FourCC(0xa9, 'w', 'r', 't')
 	Mp4ccmt = FourCC(0xa9, 'c', 'm', 't')
 	Mp4cday = FourCC(0xa9, 'd', 'a', 'y')
+	Mp4cmvi = FourCC(0xa9, 'm', 'v', 'i')
+	Mp4cmvc = FourCC(0xa9, 'm', 'v', 'c')
 	Mp4covr = FourCC('c', 'o', 'v', 'r')
 	Mp4disk = FourCC('d', 'i', 's', 'k')
 	Mp4esds = FourCC('e', 's', 'd', 's')
@@ -51,6 +55,7 @@ var (
 	Mp4stbl        = FourCC('s', 't', 'b', 'l')
 	Mp4stsd        = FourCC('s', 't', 's', 'd')
 	Mp4stts        = FourCC('s', 't', 't', 's')
+	Mp4tmpo        = FourCC('t', 'm', 'p', 'o')
 	Mp4trak        = FourCC('t', 'r', 'a', 'k')
 	Mp4trkn        = FourCC('t', 'r', 'k', 'n')
 	Mp4udta        = FourCC('u', 'd', 't', 'a')
@@ -120,6 +125,10 @@ func ReadMp4Container(fd *tools.File, id3 *Mp3Entry, sizeLeft uint64) (err error
 			if id != Mp4M4A && id != Mp4M4B && id != Mp4mp42 && id != Mp4qt && id != Mp43gp6 && id != Mp4m4a && id != Mp4isom {
 				return errors.Errorf("unknown MP4 file type: '%c%c%c%c'", id>>24&0xff, id>>16&0xff, id>>8&0xff, id&0xff)
 			}
+
+			if id == Mp4M4B && id3.Podcast.MediaKind == MediaKindUnknown {
+				id3.Podcast.MediaKind = MediaKindAudiobook
+			}
 		case Mp4meta:
 			// Skip version
 			if _, err := fd.Seek(4, io.SeekCurrent); err != nil {
@@ -360,67 +369,57 @@ func ReadMp4Tags(fd *tools.File, id3 *Mp3Entry, sizeLeft uint64) (err error) {
 
 		switch typ {
 		case Mp4cnam:
-			_, err := ReadMp4TagString(fd, size, &id3.Title)
-			if err != nil {
+			if err := ReadMp4TagValues(fd, size, id3, &id3.Title); err != nil {
 				return err
 			}
 		case Mp4cART:
-			_, err := ReadMp4TagString(fd, size, &id3.Artist)
-			if err != nil {
+			if err := ReadMp4TagValues(fd, size, id3, &id3.Artist); err != nil {
 				return err
 			}
 		case Mp4aART:
-			_, err := ReadMp4TagString(fd, size, &id3.AlbumArtist)
-			if err != nil {
+			if err := ReadMp4TagValues(fd, size, id3, &id3.AlbumArtist); err != nil {
 				return err
 			}
 		case Mp4cgrp:
-			_, err := ReadMp4TagString(fd, size, &id3.Grouping)
-			if err != nil {
+			if err := ReadMp4TagValues(fd, size, id3, &id3.Grouping); err != nil {
 				return err
 			}
 		case Mp4calb:
-			_, err := ReadMp4TagString(fd, size, &id3.Album)
-			if err != nil {
+			if err := ReadMp4TagValues(fd, size, id3, &id3.Album); err != nil {
 				return err
 			}
 		case Mp4cwrt:
-			_, err := ReadMp4TagString(fd, size, &id3.Composer)
-			if err != nil {
+			if err := ReadMp4TagValues(fd, size, id3, &id3.Composer); err != nil {
 				return err
 			}
 			cwrt = true
 		case Mp4ccmt:
-			_, err := ReadMp4TagString(fd, size, &id3.Comment)
-			if err != nil {
+			var comment string
+			if _, err := ReadMp4TagString(fd, size, &comment); err != nil {
+				return err
+			}
+			AddComment(id3, Comment{Text: comment})
+		case Mp4clyr:
+			var lyrics string
+			if _, err := ReadMp4TagString(fd, size, &lyrics); err != nil {
 				return err
 			}
+			AddTagValue(id3, &id3.Lyrics.Text, NormalizeLyrics(lyrics))
 		case Mp4cday:
-			_, err := ReadMp4TagString(fd, size, &id3.YearString)
+			var date string
+			_, err := ReadMp4TagString(fd, size, &date)
 			if err != nil {
 				return err
 			}
 
-			// Try to parse it as a year, for the benefit of the database.
-			if len(id3.YearString) >= 4 {
-				id3.YearString = string([]rune(id3.YearString)[:4])
-				allNum := true
-				for i := 0; i < len(id3.YearString); i++ {
-					if !unicode.IsNumber(rune(id3.YearString[i])) {
-						allNum = false
-					}
-				}
-
-				if allNum {
-					id3.Year, _ = strconv.Atoi(id3.YearString)
-					if id3.Year < 1900 {
-						id3.Year = 0
-					}
-				} else {
-					id3.YearString = ""
+			// Usually an ISO 8601 time stamp; parse it, for the benefit of
+			// the database. The year string only gets the year, the full
+			// date is kept in id3.ReleaseDate.
+			if parsed, ok := ParseTagDate(date); ok {
+				SetReleaseDate(id3, date)
+				if id3.YearString == "" {
+					id3.YearString = fmt.Sprintf("%04d", parsed.Year)
 				}
-			} else {
-				id3.YearString = ""
 			}
 		case Mp4gnre:
 			genre, err := ReadMp4Tag(fd, size, 2) // unsigned short genre
@@ -428,28 +427,95 @@ func ReadMp4Tags(fd *tools.File, id3 *Mp3Entry, sizeLeft uint64) (err error) {
 				return err
 			}
 
-			id3.Genre = Id3GetNumGenre(uint(include.Betoh16(genre)) + 1)
+			// The genre numbers are one greater than the ID3v1 ones
+			if g := uint(include.Betoh16(genre)); g > 0 {
+				AddTagValue(id3, &id3.Genre, Id3GetNumGenre(g-1))
+			}
 		case Mp4cgen:
-			_, err := ReadMp4TagString(fd, size, &id3.Genre)
-			if err != nil {
+			if err := ReadMp4TagValues(fd, size, id3, &id3.Genre); err != nil {
 				return err
 			}
 		case Mp4disk:
-			n, err := ReadMp4Tag(fd, size, 4) // unsigned short n[2]
+			n, err := ReadMp4Tag(fd, size, 6) // unsigned short n[3], the total last
 			if err != nil {
 				return err
 			}
 
-			id3.DiscNum = int(include.Betoh16(n[2:]))
-			id3.DiscString = fmt.Sprintf("%d", id3.DiscNum)
+			// Short atoms may lack the total, or even the number
+			if len(n) >= 4 {
+				id3.DiscNum = int(include.Betoh16(n[2:]))
+				id3.DiscString = fmt.Sprintf("%d", id3.DiscNum)
+			}
+			if len(n) >= 6 {
+				if total := int(include.Betoh16(n[4:])); total > 0 {
+					id3.DiscTotal = total
+				}
+			}
 		case Mp4trkn:
-			n, err := ReadMp4Tag(fd, size, 4) // unsigned short n[2]
+			n, err := ReadMp4Tag(fd, size, 6) // unsigned short n[3], the total last
+			if err != nil {
+				return err
+			}
+
+			// Short atoms may lack the total, or even the number
+			if len(n) >= 4 {
+				id3.TrackNum = int(include.Betoh16(n[2:]))
+				id3.TrackString = fmt.Sprintf("%d", id3.TrackNum)
+			}
+			if len(n) >= 6 {
+				if total := int(include.Betoh16(n[4:])); total > 0 {
+					id3.TrackTotal = total
+				}
+			}
+		case Mp4tmpo:
+			bpm, err := ReadMp4Tag(fd, size, 2) // unsigned short bpm
+			if err != nil {
+				return err
+			}
+
+			if id3.Bpm == 0 && len(bpm) == 2 {
+				id3.Bpm = int(include.Betoh16(bpm))
+			}
+		case Mp4cpil:
+			flag, err := ReadMp4Tag(fd, size, 1)
+			if err != nil {
+				return err
+			}
+
+			id3.Compilation = id3.Compilation || (len(flag) == 1 && flag[0] != 0)
+		case Mp4cmvi, Mp4cmvc:
+			n, err := ReadMp4Tag(fd, size, 2) // unsigned short n
+			if err != nil {
+				return err
+			}
+
+			if len(n) == 2 {
+				field := FieldMovementNumber
+				if typ == Mp4cmvc {
+					field = FieldMovementTotal
+				}
+				SetField(id3, field, fmt.Sprintf("%d", include.Betoh16(n)))
+			}
+		case Mp4stik:
+			kind, err := ReadMp4Tag(fd, size, 1)
 			if err != nil {
 				return err
 			}
 
-			id3.TrackNum = int(include.Betoh16(n[2:]))
-			id3.TrackString = fmt.Sprintf("%d", id3.TrackNum)
+			// The media kind set by iTunes beats the one guessed from the
+			// file type
+			if len(kind) == 1 {
+				id3.Podcast.MediaKind = int(kind[0])
+			}
+		case Mp4pcst:
+			flag, err := ReadMp4Tag(fd, size, 1)
+			if err != nil {
+				return err
+			}
+
+			if len(flag) == 1 && flag[0] != 0 {
+				SetPodcast(id3)
+			}
 		case Mp4covr:
 			pos, err := fd.Seek(0, io.SeekCurrent)
 			pos += 16
@@ -531,39 +597,53 @@ func ReadMp4Tags(fd *tools.File, id3 *Mp3Entry, sizeLeft uint64) (err error) {
 			}
 
 			switch {
-			case tools.Strcasecmp(tagName, "composer") && !cwrt:
-				_, err := ReadMp4TagString(fd, size, &id3.Composer)
-				if err != nil {
-					return err
+			case tools.Strcasecmp(tagName, "composer") && cwrt:
+				// The ©wrt atom takes precedence
+				if _, err = fd.Seek(int64(size), io.SeekCurrent); err != nil {
+					return errors.Wrap(err, 0)
 				}
-			case tools.Strcasecmp(tagName, "iTunSMPB"):
+			case tools.Strcasecmp(tagName, "iTunSMPB"), tools.Strcasecmp(tagName, "iTunNORM"), tools.Strcasecmp(tagName, "iTunPGAP"):
 				var value string
 				_, err := ReadMp4TagString(fd, size, &value)
 				if err != nil {
 					return err
 				}
 
-				id3.LeadTrim = int(GetiTunesInt32(value, 1))
-				id3.TailTrim = int(GetiTunesInt32(value, 2))
-			case tools.Strcasecmp(tagName, "musicbrainz track id"):
-				_, err := ReadMp4TagString(fd, size, &id3.mbTrackId)
-				if err != nil {
+				ParseITunesComment(tagName, value, id3)
+			case tools.Strcasecmp(tagName, "rate"):
+				// The user rating. Note that the rtng atom isn't a rating
+				// but the content advisory (explicit or clean).
+				var value string
+				if _, err := ReadMp4TagString(fd, size, &value); err != nil {
 					return err
 				}
-			case tools.Strcasecmp(tagName, "album artist"):
-				_, err := ReadMp4TagString(fd, size, &id3.AlbumArtist)
+				ParseRatingTag(tagName, value, id3)
+			default:
+				values, err := ReadMp4TagStrings(fd, size)
 				if err != nil {
 					return err
 				}
-			default:
-				var any string
-				if rd, err := ReadMp4TagString(fd, size, &any); err != nil {
-					return err
-				} else if rd > 0 {
-					ParseReplayGain(tagName, any, id3)
+
+				field, _ := CanonicalField(Mp4FreeformPrefix+tagName, TagTypeMp4)
+				for _, value := range values {
+					if !SetField(id3, field, value) {
+						ParseReplayGain(tagName, value, id3)
+					}
 				}
 			}
 		default:
+			// Other text atoms are looked up in FieldTable
+			if field, ok := CanonicalField(Mp4AtomName(typ), TagTypeMp4); ok {
+				values, err := ReadMp4TagStrings(fd, size)
+				if err != nil {
+					return err
+				}
+				for _, value := range values {
+					SetField(id3, field, value)
+				}
+				break
+			}
+
 			if _, err = fd.Seek(int64(size), io.SeekCurrent); err != nil {
 				return errors.Wrap(err, 0)
 			}
@@ -575,21 +655,69 @@ func ReadMp4Tags(fd *tools.File, id3 *Mp3Entry, sizeLeft uint64) (err error) {
 
 // Read a string tag from an MP4 file
 func ReadMp4TagString(fd *tools.File, size uint32, s *string) (i int, err error) {
-	buf, err := ReadMp4Tag(fd, size, int(size))
+	values, err := ReadMp4TagStrings(fd, size)
 	if err != nil {
 		return 0, err
 	}
 
-	if len(buf) > 0 {
+	if len(values) > 0 {
 		// Do not overwrite already available metadata. Especially when reading
 		// tags with e.g. multiple genres / artists. This way only the first
 		// of multiple entries is used, all following are dropped.
 		if *s == "" {
-			*s = string(buf)
+			*s = values[0]
+		}
+		i = len(values[0])
+	}
+
+	return i, nil
+}
+
+// Read a string tag from an MP4 file into a field of id3 that can hold
+// several values, see AddTagValue.
+func ReadMp4TagValues(fd *tools.File, size uint32, id3 *Mp3Entry, p *string) error {
+	values, err := ReadMp4TagStrings(fd, size)
+	if err != nil {
+		return err
+	}
+
+	for _, value := range values {
+		AddTagValue(id3, p, value)
+	}
+
+	return nil
+}
+
+// Read all values of a string tag from an MP4 file. Each value is stored in
+// a "data" atom of its own.
+func ReadMp4TagStrings(fd *tools.File, size uint32) ([]string, error) {
+	if size == 0 {
+		return nil, nil
+	}
+
+	buf := make([]byte, size)
+	if rd, err := fd.Read(buf); err != nil {
+		return nil, errors.Wrap(err, 0)
+	} else if rd < len(buf) {
+		return nil, errors.New("failed to read mp4 tag")
+	}
+
+	var values []string
+	for len(buf) >= 16 {
+		n := int(GetLongBE(buf))
+		if n < 16 || n > len(buf) {
+			// Not a proper atom, use the data as is
+			n = len(buf)
+		}
+
+		// Skip the data tag header - maybe we should parse it properly?
+		if n > 16 {
+			values = append(values, string(buf[16:n]))
 		}
+		buf = buf[n:]
 	}
 
-	return 0, nil
+	return values, nil
 }
 
 func ReadMp4Atom(fd *tools.File, sizeLeft uint64) (sl uint64, size uint32, typ uint32, err error) {
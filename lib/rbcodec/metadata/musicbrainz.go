@@ -0,0 +1,122 @@
+package metadata
+
+import (
+	"strings"
+)
+
+// Whether field is one of the MusicBrainz identifiers
+func IsMusicBrainzField(field Field) bool {
+	return strings.HasPrefix(string(field), "musicbrainz_")
+}
+
+// Whether s is a UUID in its textual form, e.g.
+// "f4a31f0a-51dd-4fa7-986d-3095c40c5ed9"
+func IsUuid(s string) bool {
+	if len(s) != 36 {
+		return false
+	}
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch i {
+		case 8, 13, 18, 23:
+			if c != '-' {
+				return false
+			}
+		default:
+			if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F') {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// Whether s is a MusicBrainz disc id: 28 characters of a base64 variant
+func isMusicBrainzDiscId(s string) bool {
+	if len(s) != 28 {
+		return false
+	}
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '.' || c == '_' || c == '-') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// The identifier of id3 that holds field, nil if it isn't a single
+// MusicBrainz identifier
+func (id3 *Mp3Entry) musicBrainzId(field Field) *string {
+	mb := &id3.MusicBrainz
+	switch field {
+	case FieldMusicBrainzTrackId:
+		return &mb.TrackId
+	case FieldMusicBrainzReleaseTrackId:
+		return &mb.ReleaseTrackId
+	case FieldMusicBrainzAlbumId:
+		return &mb.AlbumId
+	case FieldMusicBrainzReleaseGroupId:
+		return &mb.ReleaseGroupId
+	case FieldMusicBrainzWorkId:
+		return &mb.WorkId
+	case FieldMusicBrainzDiscId:
+		return &mb.DiscId
+	}
+	return nil
+}
+
+// The identifiers of id3 that hold field, nil if it isn't a list of
+// MusicBrainz identifiers
+func (id3 *Mp3Entry) musicBrainzIds(field Field) *[]string {
+	switch field {
+	case FieldMusicBrainzArtistId:
+		return &id3.MusicBrainz.ArtistIds
+	case FieldMusicBrainzAlbumArtistId:
+		return &id3.MusicBrainz.AlbumArtistIds
+	}
+	return nil
+}
+
+// Store a MusicBrainz identifier in id3. Identifiers that aren't well formed
+// are dropped, an identifier that is already set is kept. Lists of artist
+// ids may also be given as a single value, separated by '/' or ';'. Returns
+// false if field isn't a MusicBrainz identifier.
+func SetMusicBrainzId(id3 *Mp3Entry, field Field, value string) bool {
+	value = strings.TrimSpace(value)
+
+	if p := id3.musicBrainzId(field); p != nil {
+		if field == FieldMusicBrainzDiscId {
+			if *p == "" && isMusicBrainzDiscId(value) {
+				*p = value
+			}
+		} else if *p == "" && IsUuid(value) {
+			*p = strings.ToLower(value)
+		}
+		return true
+	}
+
+	if ids := id3.musicBrainzIds(field); ids != nil {
+		for _, id := range strings.FieldsFunc(value, func(r rune) bool { return r == '/' || r == ';' }) {
+			id = strings.ToLower(strings.TrimSpace(id))
+			if !IsUuid(id) {
+				continue
+			}
+
+			known := false
+			for _, v := range *ids {
+				known = known || v == id
+			}
+			if !known {
+				*ids = append(*ids, id)
+			}
+		}
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,273 @@
+package metadata
+
+import (
+	"github.com/go-errors/errors"
+	"io"
+	"rbmetadata-go/firmware/common"
+	"rbmetadata-go/tools"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	PsfHeaderSize = 16
+	// The tag must not be longer than this, according to the spec
+	PsfMaxTagSize = 50000
+	PsfTagMarker  = "[TAG]"
+)
+
+// Platform names, indexed by the version byte of the header
+var PsfPlatforms = map[byte]string{
+	0x01: "PlayStation",
+	0x02: "PlayStation 2",
+	0x11: "Sega Saturn",
+	0x12: "Sega Dreamcast",
+	0x13: "Sega Mega Drive",
+	0x21: "Nintendo 64",
+	0x22: "Game Boy Advance",
+	0x23: "Super Nintendo",
+	0x24: "Nintendo DS",
+	0x41: "Capcom QSound",
+}
+
+// Version byte expected for platform specific file extensions
+var PsfExtVersions = map[string]byte{
+	"psf":      0x01,
+	"minipsf":  0x01,
+	"psf1":     0x01,
+	"minipsf1": 0x01,
+	"psf2":     0x02,
+	"minipsf2": 0x02,
+	"ssf":      0x11,
+	"minissf":  0x11,
+	"minidsf":  0x12,
+	"usf":      0x21,
+	"miniusf":  0x21,
+	"gsf":      0x22,
+	"minigsf":  0x22,
+	"snsf":     0x23,
+	"minisnsf": 0x23,
+	"2sf":      0x24,
+	"mini2sf":  0x24,
+	"qsf":      0x41,
+	"miniqsf":  0x41,
+}
+
+func GetPsfMetadata(fd *tools.File, id3 *Mp3Entry) error {
+	var buf [PsfHeaderSize]byte
+
+	if _, err := fd.Seek(0, io.SeekStart); err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	if rd, err := fd.Read(buf[:]); err != nil {
+		return errors.Wrap(err, 0)
+	} else if rd < len(buf) || string(buf[:3]) != "PSF" {
+		return errors.New("not a psf file")
+	}
+
+	version := buf[3]
+	platform, ok := PsfPlatforms[version]
+	if !ok {
+		return errors.Errorf("unknown psf version 0x%02x", version)
+	}
+
+	ext := strings.ToLower(fileExtension(fd.Name()))
+	if expected, ok := PsfExtVersions[ext]; ok && expected != version {
+		return errors.Errorf("psf version 0x%02x doesn't match .%s file", version, ext)
+	}
+
+	id3.Psf = PsfInfo{
+		Version:  version,
+		Platform: platform,
+	}
+
+	id3.VBR = false
+	id3.Filesize = fd.FileSize()
+
+	// we only render 16 bits, 44.1KHz, Stereo
+	id3.Bitrate = 706
+	id3.Frequency = 44100
+
+	// The tag follows the reserved area and the compressed program
+	reservedSize := GetLongLE(buf[4:])
+	programSize := GetLongLE(buf[8:])
+	tagPos := int64(PsfHeaderSize) + int64(reservedSize) + int64(programSize)
+
+	if uint64(tagPos)+uint64(len(PsfTagMarker)) > id3.Filesize {
+		// No tag
+		return nil
+	}
+
+	if _, err := fd.Seek(tagPos, io.SeekStart); err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	tagSize := id3.Filesize - uint64(tagPos)
+	if tagSize > PsfMaxTagSize+uint64(len(PsfTagMarker)) {
+		tagSize = PsfMaxTagSize + uint64(len(PsfTagMarker))
+	}
+
+	tag := make([]byte, tagSize)
+	if rd, err := fd.Read(tag); err != nil {
+		return errors.Wrap(err, 0)
+	} else {
+		tag = tag[:rd]
+	}
+
+	if tools.StrSl(tag, len(PsfTagMarker)) != PsfTagMarker {
+		return nil
+	}
+
+	return ParsePsfTag(tag[len(PsfTagMarker):], id3)
+}
+
+// Parse the text following the [TAG] marker. Each line is a key=value pair;
+// keys are case insensitive and values of repeated keys are joined with a
+// newline.
+func ParsePsfTag(tag []byte, id3 *Mp3Entry) error {
+	var keys []string
+	values := map[string][]byte{}
+
+	for _, line := range strings.Split(string(tag), "\n") {
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) < 2 {
+			continue
+		}
+
+		key := strings.ToLower(psfTrim(parts[0]))
+		value := psfTrim(parts[1])
+		if key == "" {
+			continue
+		}
+
+		if old, ok := values[key]; ok {
+			values[key] = append(append(old, '\n'), value...)
+		} else {
+			keys = append(keys, key)
+			values[key] = []byte(value)
+		}
+	}
+
+	// The encoding is only known after the whole tag was read
+	id3.Psf.Utf8 = string(values["utf8"]) != "" && string(values["utf8"]) != "0"
+
+	decode := func(b []byte) string {
+		if id3.Psf.Utf8 {
+			return string(b)
+		}
+		return common.IsoDecode(b, -1)
+	}
+
+	libs := map[int]string{}
+	for _, key := range keys {
+		value := decode(values[key])
+
+		switch {
+		case key == "title":
+			id3.Title = value
+		case key == "artist":
+			id3.Artist = value
+		case key == "game":
+			id3.Album = value
+		case key == "genre":
+			id3.Genre = value
+		case key == "comment":
+			id3.Comment = value
+		case key == "copyright":
+			id3.Psf.Copyright = value
+			id3.Copyright = value
+		case key == "year":
+			id3.YearString = value
+			if len(value) >= 4 {
+				if year, err := strconv.Atoi(value[:4]); err == nil {
+					id3.Year = year
+				}
+			}
+		case key == "length":
+			id3.Psf.SongLength = ParsePsfTime(value)
+		case key == "fade":
+			id3.Psf.Fade = ParsePsfTime(value)
+		case strings.HasPrefix(key, "_lib"):
+			// _lib is loaded first, then _lib2, _lib3, ... in numeric order
+			n := 1
+			if key != "_lib" {
+				var err error
+				if n, err = strconv.Atoi(key[4:]); err != nil || n < 2 {
+					continue
+				}
+			}
+			libs[n] = value
+		case strings.HasSuffix(key, "by") && len(key) <= 6:
+			// psfby, 2sfby, gsfby, usfby, ...
+			if id3.Psf.RippedBy == "" {
+				id3.Psf.RippedBy = value
+			}
+		}
+	}
+
+	var libNums []int
+	for n := range libs {
+		libNums = append(libNums, n)
+	}
+	sort.Ints(libNums)
+	for _, n := range libNums {
+		id3.Psf.Libs = append(id3.Psf.Libs, libs[n])
+	}
+
+	if id3.Psf.SongLength > 0 {
+		id3.Length = id3.Psf.SongLength + id3.Psf.Fade
+	}
+
+	return nil
+}
+
+// Parse a PSF time value: "[[hh:]mm:]ss[.fff]", where the decimal separator
+// may also be a comma. Returns the time in ms, or 0 if it can't be parsed.
+func ParsePsfTime(value string) uint64 {
+	var ms uint64
+
+	value = strings.Replace(value, ",", ".", 1)
+	parts := strings.Split(value, ":")
+	if len(parts) > 3 {
+		return 0
+	}
+
+	for i, part := range parts {
+		if i < len(parts)-1 {
+			n, err := strconv.ParseUint(part, 10, 64)
+			if err != nil {
+				return 0
+			}
+			ms = (ms + n*1000) * 60
+			continue
+		}
+
+		// Seconds, with an optional fraction
+		secs := strings.SplitN(part, ".", 2)
+		n, err := strconv.ParseUint(secs[0], 10, 64)
+		if err != nil && secs[0] != "" {
+			return 0
+		}
+		ms += n * 1000
+
+		if len(secs) == 2 {
+			frac := (secs[1] + "000")[:3]
+			f, err := strconv.ParseUint(frac, 10, 64)
+			if err != nil {
+				return 0
+			}
+			ms += f
+		}
+	}
+
+	return ms
+}
+
+// Trim whitespace the way the PSF spec defines it: any character <= 0x20
+func psfTrim(s string) string {
+	return strings.TrimFunc(s, func(r rune) bool {
+		return r <= 0x20
+	})
+}
@@ -0,0 +1,31 @@
+package metadata
+
+import "testing"
+
+func TestParsePsfTime(t *testing.T) {
+	tests := []struct {
+		value string
+		want  uint64
+	}{
+		{"0", 0},
+		{"45", 45000},
+		{"3:05", 185000},
+		{"1:02:03", 3723000},
+		{"2:30.5", 150500},
+		{"2:30,25", 150250},
+		{"10.1234", 10123},
+		{".5", 500},
+		{"1:2:3:4", 0},
+		{"a:30", 0},
+		{"30.x", 0},
+		{"", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			if got := ParsePsfTime(tt.value); got != tt.want {
+				t.Errorf("ParsePsfTime(%q) = %d, want %d", tt.value, got, tt.want)
+			}
+		})
+	}
+}
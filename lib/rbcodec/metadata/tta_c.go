@@ -47,29 +47,37 @@ func ReadId3Tags(fd *tools.File, id3 *Mp3Entry) error {
 	id3.Title = ""
 	id3.Filesize = fd.FileSize()
 
-	if n, err := GetId3v2Len(fd); err != nil {
+	if n, err := GetId3v2TagLen(fd, 0); err != nil {
 		return errors.Wrap(err, 0)
 	} else {
 		id3.Id3v2len = uint64(n)
 	}
 
-	id3.TrackNum = 0
-	id3.DiscNum = 0
-	id3.VBR = false // All TTA files are CBR
-
-	// first get id3v2 tags. if no id3v2 tags ware found, get id3v1 tags
-	if id3.Id3v2len > 0 {
-		if err := SetId3v2Title(fd, id3); err != nil {
-			return errors.Wrap(err, 0)
-		}
-		id3.FirstFrameOffset = int64(id3.Id3v2len)
-	}
-
-	if err := SetId3v1Title(fd, id3); err != nil {
+	if n, err := GetId3v1Len(fd); err != nil {
 		return errors.Wrap(err, 0)
+	} else {
+		id3.Id3v1len = uint64(n)
 	}
 
-	return nil
+	id3.TrackNum = 0
+	id3.DiscNum = 0
+	id3.VBR = false // All TTA files are CBR
+	id3.FirstFrameOffset = int64(id3.Id3v2len)
+
+	return ReadTagBlocks(id3, []TagBlockReader{
+		{TagBlockId3v2, func() error {
+			return ReadLeadingId3v2Tag(fd, id3)
+		}},
+		{TagBlockApe, func() error {
+			return ReadApeTags(fd, id3)
+		}},
+		{TagBlockId3v1, func() error {
+			if id3.Id3v1len == 0 {
+				return nil
+			}
+			return SetId3v1Title(fd, id3)
+		}},
+	})
 }
 
 func GetTtaMetadata(fd *tools.File, id3 *Mp3Entry) error {
@@ -0,0 +1,11 @@
+package rbapi
+
+import (
+	"rbmetadata-go/lib/rbcodec/metadata"
+)
+
+func GetAlbumArt(filename string) (id3 metadata.Mp3Entry, data []byte, err error) {
+	data, err = metadata.AlbumArt(&id3, filename)
+
+	return
+}
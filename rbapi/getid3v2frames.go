@@ -0,0 +1,9 @@
+package rbapi
+
+import (
+	"rbmetadata-go/lib/rbcodec/metadata"
+)
+
+func GetId3v2Frames(filename string) ([]metadata.Id3Frame, error) {
+	return metadata.Id3v2Frames(filename)
+}
@@ -0,0 +1,14 @@
+package rbapi
+
+import (
+	"rbmetadata-go/lib/rbcodec/metadata"
+)
+
+func GetLrc(filename string) (lrc string, err error) {
+	id3, err := GetMetaData(filename)
+	if err != nil {
+		return "", err
+	}
+
+	return metadata.ExportLrc(&id3)
+}
@@ -9,3 +9,12 @@ func GetMetaData(filename string) (id3 metadata.Mp3Entry, err error) {
 
 	return
 }
+
+// Read the metadata of filename, merging the fields of several tag blocks
+// by policy.
+func GetMetaDataWithPolicy(filename string, policy metadata.MergePolicy) (id3 metadata.Mp3Entry, err error) {
+	id3.MergePolicy = policy
+	err = metadata.Mp3Info(&id3, filename)
+
+	return
+}